@@ -13,6 +13,35 @@ const (
 	cliDescription = "kubelistener listens to Kubernetes events and outputs them to specified locations."
 )
 
+// stringArray is a repeatable flag whose values are not split on commas,
+// sink URIs may contain them.
+type stringArray struct {
+	value *[]string
+	changed bool
+}
+
+func newStringArray(p *[]string) *stringArray {
+	return &stringArray{value: p}
+}
+
+func (s *stringArray) Set(val string) error {
+	if !s.changed {
+		*s.value = []string{val}
+		s.changed = true
+	} else {
+		*s.value = append(*s.value, val)
+	}
+	return nil
+}
+
+func (s *stringArray) Type() string {
+	return "stringArray"
+}
+
+func (s *stringArray) String() string {
+	return strings.Join(*s.value, " ")
+}
+
 func main() {
 	// configuration
 	cfg := pkg.NewConfig()
@@ -20,10 +49,48 @@ func main() {
 	// flags
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	fs.StringVar(&cfg.KubeMasterURL, "kube-master-url", cfg.KubeMasterURL, "URL to reach kubernetes master.")
+	fs.StringVar(&cfg.KubeConfig, "kubeconfig", cfg.KubeConfig, "Path to a kubeconfig file to run outside the cluster, the in-cluster service account is used if not set.")
+	fs.StringVar(&cfg.KubeContext, "context", cfg.KubeContext, "The kubeconfig context to use instead of its current one.")
+	fs.StringVar(&cfg.TokenFile, "token-file", cfg.TokenFile, "File containing a bearer token to authenticate with, instead of the service account one.")
+	fs.StringVar(&cfg.ClientCertificate, "client-certificate", cfg.ClientCertificate, "PEM client certificate file to authenticate with, requires --client-key.")
+	fs.StringVar(&cfg.ClientKey, "client-key", cfg.ClientKey, "PEM private key file of the client certificate.")
+	fs.StringVar(&cfg.Username, "username", cfg.Username, "Username for basic authentication, requires --password-file.")
+	fs.StringVar(&cfg.PasswordFile, "password-file", cfg.PasswordFile, "File containing the password for basic authentication.")
+	fs.StringVar(&cfg.CertificateAuthority, "certificate-authority", cfg.CertificateAuthority, "PEM CA certificate file to verify the master with, instead of the service account one.")
+	fs.DurationVar(&cfg.CredentialsReloadInterval, "credentials-reload-interval", cfg.CredentialsReloadInterval, "How often token, certificate, key and CA files are checked for changes, 0 to only reload them when the credentials are rejected.")
 	fs.StringVar(&cfg.Namespace, "namespace", cfg.Namespace, "If present, the namespace scope.")
-	fs.StringVar(&cfg.Resource, "resource", cfg.Resource, "Which resource to watch.")
-	fs.StringVar(&cfg.Selector, "selector", cfg.Selector, "Filter resources by a user-provided selector.")
+	fs.BoolVar(&cfg.AllNamespaces, "all-namespaces", cfg.AllNamespaces, "Watch every namespace in the cluster, can not be combined with --namespace.")
+	fs.StringSliceVar(&cfg.IncludeNamespaces, "include-namespaces", cfg.IncludeNamespaces, "Comma separated glob patterns of the namespaces to emit events for, all if empty.")
+	fs.StringSliceVar(&cfg.ExcludeNamespaces, "exclude-namespaces", cfg.ExcludeNamespaces, "Comma separated glob patterns of the namespaces to skip, e.g. 'kube-system'.")
+	fs.StringVar(&cfg.Resource, "resource", cfg.Resource, "Which resources to watch, a comma separated list of names or short names (e.g. svc,po), group/version/resource (e.g. extensions/v1beta1/deployments) or 'all'.")
+	fs.StringVar(&cfg.Selector, "selector", cfg.Selector, "Filter resources by a user-provided label selector.")
+	fs.StringVar(&cfg.FieldSelector, "field-selector", cfg.FieldSelector, "Filter resources by a user-provided field selector, e.g. 'spec.nodeName=node-1'.")
+	fs.StringVar(&cfg.Patch, "patch", cfg.Patch, "Attach a patch from the previous to the new object to MODIFIED events: 'json' (RFC 6902) or 'merge' (RFC 7386).")
+	fs.StringSliceVar(&cfg.WatchFields, "watch-fields", cfg.WatchFields, "Comma separated field paths, only MODIFIED events changing any of them are emitted, e.g. 'spec.ports,{.metadata.labels}'.")
+	fs.BoolVar(&cfg.Dedupe, "dedupe", cfg.Dedupe, "Drop MODIFIED events only changing the resource version, self link, status timestamps or any of the ignored fields.")
+	fs.StringSliceVar(&cfg.IgnoreFields, "ignore-fields", cfg.IgnoreFields, "Comma separated field paths not considered a change when deduping, e.g. 'metadata.annotations,status.observedGeneration'.")
+	fs.StringVar(&cfg.ClientSelector, "client-selector", cfg.ClientSelector, "Label selector evaluated locally, objects starting or stopping to match it are reported as ENTERED and LEFT events.")
 	fs.DurationVar(&cfg.ResyncInterval, "resync-interval", cfg.ResyncInterval, "Resync with kubernetes master every user-defined interval.")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", cfg.PollInterval, "How often to list the resources which can not be watched, e.g. componentstatuses.")
+	fs.DurationVar(&cfg.BackoffInitialDelay, "backoff-initial-delay", cfg.BackoffInitialDelay, "Delay before retrying a failed list or watch, doubled on every consecutive failure.")
+	fs.DurationVar(&cfg.BackoffMaxDelay, "backoff-max-delay", cfg.BackoffMaxDelay, "Maximum delay between list or watch retries.")
+	fs.DurationVar(&cfg.BackoffResetAfter, "backoff-reset-after", cfg.BackoffResetAfter, "Failures separated by more than this start again from the initial delay.")
+	fs.IntVar(&cfg.BufferSize, "buffer-size", cfg.BufferSize, "Events buffered in memory while outputs catch up.")
+	fs.StringVar(&cfg.Backpressure, "backpressure", cfg.Backpressure, "What to do when the buffer is full: 'block', 'drop-newest', 'drop-oldest' or 'spill' to disk.")
+	fs.StringVar(&cfg.SpillDir, "spill-dir", cfg.SpillDir, "Directory holding the events overflowed by the 'spill' backpressure policy.")
+	fs.Int64Var(&cfg.SpillLimit, "spill-limit", cfg.SpillLimit, "Maximum size in bytes of the spilled events, the 'spill' policy blocks beyond it.")
+	fs.StringVar(&cfg.WALDir, "wal-dir", cfg.WALDir, "Directory holding a write-ahead log of the events and the resume checkpoint, enables at-least-once delivery across restarts. Exec commands then run synchronously and the drop policies are not allowed.")
+	fs.IntVar(&cfg.DeliveryAttempts, "delivery-attempts", cfg.DeliveryAttempts, "With a write-ahead log, how many times delivering an event to failing sinks is attempted before discarding it, 0 to retry forever. Events rejected by a sink, e.g. with a 4xx response, are not retried.")
+	fs.IntVar(&cfg.FlushEvents, "flush-events", cfg.FlushEvents, "Flush the outputs, acknowledging the delivered events in the write-ahead log, at least every this many events. 0 to only flush when idle.")
+	fs.DurationVar(&cfg.FlushInterval, "flush-interval", cfg.FlushInterval, "Flush the outputs, acknowledging the delivered events in the write-ahead log, at least this often while busy. 0 to only flush when idle.")
+	fs.DurationVar(&cfg.DrainTimeout, "drain-timeout", cfg.DrainTimeout, "How long the queued events are delivered for after being asked to stop, the rest are discarded.")
+	fs.Var(newStringArray(&cfg.Sinks), "sink", "Output URI (file:///path, stdout://, ...), restrict event types with '?events=added,deleted'. May be repeated.")
+	fs.StringVar(&cfg.OutputFormat, "output-format", cfg.OutputFormat, "How events are written: 'json' (one envelope per line), 'yaml', 'diff' (a unified diff per MODIFIED object) or 'template=<file>'. Overridable per sink with '?format='.")
+	fs.DurationVar(&cfg.WebhookTimeout, "webhook-timeout", cfg.WebhookTimeout, "Timeout for each request made by http(s):// sinks.")
+	fs.IntVar(&cfg.WebhookRetries, "webhook-retries", cfg.WebhookRetries, "How many times http(s):// sinks retry on connection errors and 5xx responses.")
+	fs.DurationVar(&cfg.WebhookBackoff, "webhook-backoff", cfg.WebhookBackoff, "Delay before the first retry of http(s):// sinks, doubled on every attempt.")
+	fs.Var(newStringArray(&cfg.WebhookHeaders), "webhook-header", "Header added to the requests made by http(s):// sinks, as 'Name: value'. May be repeated.")
+	fs.StringVar(&cfg.WebhookSecretFile, "webhook-secret-file", cfg.WebhookSecretFile, "File containing the shared secret used to sign the http(s):// sink requests in the X-Kubelistener-Signature header.")
 	fs.StringVar(&cfg.AddEventsFile, "add-events-file", cfg.AddEventsFile, "File in which the events of type 'add' are printed.")
 	fs.StringVar(&cfg.UpdateEventsFile, "update-events-file", cfg.UpdateEventsFile, "File in which the events of type 'update' are printed.")
 	fs.StringVar(&cfg.DeleteEventsFile, "delete-events-file", cfg.DeleteEventsFile, "File in which the events of type 'delete' are printed.")
@@ -0,0 +1,55 @@
+// Package meta provides generic access to the metadata of API objects,
+// regardless of their concrete type.
+package meta
+
+import (
+	"fmt"
+
+	"github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+)
+
+// Object is implemented by every API object carrying object metadata.
+type Object interface {
+	GetNamespace() string
+	GetName() string
+	GetUID() unversioned.UID
+	GetResourceVersion() string
+	GetLabels() map[string]string
+	GetAnnotations() map[string]string
+}
+
+// Type is implemented by every API object carrying type metadata.
+type Type interface {
+	GetAPIVersion() string
+	GetKind() string
+}
+
+// List is implemented by every API list carrying list metadata.
+type List interface {
+	GetSelfLink() string
+	GetResourceVersion() string
+}
+
+// Accessor returns the object metadata of obj.
+func Accessor(obj interface{}) (Object, error) {
+	if m, ok := obj.(Object); ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("%T does not have object metadata", obj)
+}
+
+// TypeAccessor returns the type metadata of obj.
+func TypeAccessor(obj interface{}) (Type, error) {
+	if t, ok := obj.(Type); ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("%T does not have type metadata", obj)
+}
+
+// ListAccessor returns the list metadata of obj.
+func ListAccessor(obj interface{}) (List, error) {
+	if l, ok := obj.(List); ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("%T does not have list metadata", obj)
+}
@@ -0,0 +1,11 @@
+package unversioned
+
+// Accessors for meta.Type, promoted to every type embedding TypeMeta.
+
+func (obj *TypeMeta) GetAPIVersion() string { return obj.APIVersion }
+func (obj *TypeMeta) GetKind() string       { return obj.Kind }
+
+// Accessors for meta.List, promoted to every type embedding ListMeta.
+
+func (obj *ListMeta) GetSelfLink() string        { return obj.SelfLink }
+func (obj *ListMeta) GetResourceVersion() string { return obj.ResourceVersion }
@@ -162,6 +162,17 @@ const (
 // Status code 409
 	StatusReasonConflict StatusReason = "Conflict"
 
+// StatusReasonGone means the item is no longer available at the server and no
+// forwarding address is known.
+// Status code 410
+	StatusReasonGone StatusReason = "Gone"
+
+// StatusReasonExpired indicates that the request is invalid because the content you are requesting
+// has expired and is no longer available. It is typically associated with watches that can't be
+// serviced.
+// Status code 410 (gone)
+	StatusReasonExpired StatusReason = "Expired"
+
 // StatusReasonInvalid means the requested create or update operation cannot be
 // completed due to invalid data provided as part of the request. The client may
 // need to alter the request. When set, the client may use the StatusDetails
@@ -321,6 +332,15 @@ type APIResource struct {
 	Name string `json:"name"`
 	// namespaced indicates if a resource is namespaced or not.
 	Namespaced bool `json:"namespaced"`
+	// kind is the kind for the resource, e.g. 'Foo' is the kind for a
+	// resource 'foo'. Not reported by every server.
+	Kind string `json:"kind,omitempty"`
+	// singularName is the singular name of the resource.
+	SingularName string `json:"singularName,omitempty"`
+	// shortNames is a list of suggested short names of the resource.
+	ShortNames []string `json:"shortNames,omitempty"`
+	// verbs is a list of supported kube verbs, e.g. 'list' or 'watch'.
+	Verbs []string `json:"verbs,omitempty"`
 }
 
 // APIResourceList is a list of APIResource, it is used to expose the name of the
@@ -0,0 +1,14 @@
+package v1
+
+import (
+	"github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+)
+
+// Accessors for meta.Object, promoted to every type embedding ObjectMeta.
+
+func (obj *ObjectMeta) GetNamespace() string              { return obj.Namespace }
+func (obj *ObjectMeta) GetName() string                   { return obj.Name }
+func (obj *ObjectMeta) GetUID() unversioned.UID           { return obj.UID }
+func (obj *ObjectMeta) GetResourceVersion() string        { return obj.ResourceVersion }
+func (obj *ObjectMeta) GetLabels() map[string]string      { return obj.Labels }
+func (obj *ObjectMeta) GetAnnotations() map[string]string { return obj.Annotations }
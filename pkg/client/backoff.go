@@ -0,0 +1,112 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+	log "github.com/glerchundi/logrus"
+)
+
+// Random amount added to every delay, as a fraction of it.
+const backoffJitter = 0.5
+
+// BackoffConfig configures the retries of an Informer, non-positive delays
+// are replaced by the defaults.
+type BackoffConfig struct {
+	// Delay after the first failure.
+	InitialDelay time.Duration
+	// Upper bound for the delay, doubled on every consecutive failure.
+	MaxDelay time.Duration
+	// Failures separated by more than this are not consecutive.
+	ResetAfter time.Duration
+}
+
+func NewBackoffConfig() *BackoffConfig {
+	return &BackoffConfig{
+		InitialDelay: 1 * time.Second,
+		MaxDelay: 2 * time.Minute,
+		ResetAfter: 5 * time.Minute,
+	}
+}
+
+// backoff computes exponential delays with jitter between the attempts of
+// a retry loop, it is not safe for concurrent use.
+type backoff struct {
+	name string
+	config *BackoffConfig
+	rand *rand.Rand
+	// consecutive failures
+	failures int
+	// base delay for the next failure
+	delay time.Duration
+	lastFailure time.Time
+}
+
+func newBackoff(name string, config *BackoffConfig) *backoff {
+	// a zero delay would retry in a tight loop
+	c := *config
+	defaults := NewBackoffConfig()
+	if c.InitialDelay <= 0 {
+		c.InitialDelay = defaults.InitialDelay
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = defaults.MaxDelay
+	}
+	return &backoff{
+		name: name,
+		config: &c,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next registers a failure returning how long to wait before retrying. It
+// waits at least the delay requested by the server, if any.
+func (b *backoff) next(err error) time.Duration {
+	now := time.Now()
+	if b.failures == 0 || now.Sub(b.lastFailure) > b.config.ResetAfter {
+		b.failures = 0
+		b.delay = b.config.InitialDelay
+	}
+	b.failures++
+	b.lastFailure = now
+
+	d := b.delay
+	if jitter := int64(float64(d) * backoffJitter); jitter > 0 {
+		d += time.Duration(b.rand.Int63n(jitter))
+	}
+	if d > b.config.MaxDelay {
+		d = b.config.MaxDelay
+	}
+	if retryAfter := retryAfter(err); retryAfter > d {
+		d = retryAfter
+	}
+
+	if b.delay *= 2; b.delay > b.config.MaxDelay {
+		b.delay = b.config.MaxDelay
+	}
+
+	return d
+}
+
+// wait sleeps after a failure, returning early if ctx is done.
+func (b *backoff) wait(ctx context.Context, err error) {
+	d := b.next(err)
+	log.Infof("%s failed %d consecutive times, retrying in %v (next base delay %v)", b.name, b.failures, d, b.delay)
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// retryAfter returns the delay requested by the server through a Status.
+func retryAfter(err error) time.Duration {
+	se, ok := err.(*StatusError)
+	if !ok || se.Status.Details == nil {
+		return 0
+	}
+	return time.Duration(se.Status.Details.RetryAfterSeconds) * time.Second
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff("test", &BackoffConfig{InitialDelay: time.Second, MaxDelay: 3 * time.Second, ResetAfter: time.Minute})
+	for _, base := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if d := b.next(errors.New("failed")); d < base || d > 3*time.Second {
+			t.Errorf("got delay %v, expected it between %v and %v", d, base, 3*time.Second)
+		}
+	}
+
+	// the server may ask for longer
+	err := &StatusError{&unversioned.Status{Details: &unversioned.StatusDetails{RetryAfterSeconds: 10}}}
+	if d := b.next(err); d != 10*time.Second {
+		t.Errorf("got delay %v, expected the requested 10s", d)
+	}
+
+	// failures separated by more than ResetAfter start over
+	b.lastFailure = time.Now().Add(-2 * time.Minute)
+	if d := b.next(errors.New("failed")); d >= 2*time.Second {
+		t.Errorf("got delay %v after a while, expected it to be reset", d)
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	b := newBackoff("test", &BackoffConfig{})
+	defaults := NewBackoffConfig()
+	if d := b.next(errors.New("failed")); d < defaults.InitialDelay {
+		t.Errorf("got delay %v without config, expected at least %v", d, defaults.InitialDelay)
+	}
+}
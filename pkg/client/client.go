@@ -2,107 +2,62 @@ package client
 
 import (
 	"crypto/tls"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/gorilla/websocket"
-	"golang.org/x/net/context"
-	"golang.org/x/net/context/ctxhttp"
 	log "github.com/glerchundi/logrus"
-	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
-	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
 )
 
 type Client struct {
 	// derived config
-	tls *tls.Config
-	reqHeader http.Header
+	secure bool
+	credentials *CredentialProvider
 	baseURL string
 	// user-provided configuration
 	config *ClientConfig
+	// cached API discovery
+	discovery *Discovery
+	discoveryMu sync.Mutex
 }
 
 type ClientConfig struct {
 	MasterURL string
 	Auth ClientAuth
 	CaCertificate []byte
+	// CaCertificateFile, if set, takes precedence over CaCertificate and is
+	// reloaded when it changes.
+	CaCertificateFile string
 }
 
+// ClientAuth holds the credentials of a client. Files, if set, take
+// precedence over inline values and are reloaded when they change.
 type ClientAuth interface {
 }
 
 type ClientCertificateAuth struct {
 	ClientCertificate []byte
 	ClientKey []byte
+	ClientCertificateFile string
+	ClientKeyFile string
 }
 
 type TokenAuth struct {
 	Token string
+	TokenFile string
 }
 
 type UsernameAndPasswordAuth struct {
 	Username string
 	Password string
+	PasswordFile string
 }
 
-type Informer struct {
-	// derived config
-	httpClient *http.Client
-	httpReq *http.Request
-	wsURL string
-	wsDialer *websocket.Dialer
-	wsHeader http.Header
-	// user-provided configuration
-	config *InformerConfig
-	// inter-routine comm.
-	rc resourceCreator
-	recvChan chan<- interface{}
-	// control flow channels
-	stopChan <-chan struct{}
-	doneChan chan bool
-	errChan chan error
-}
-
-type InformerConfig struct {
-	Namespace string
-	Resource string
-	Selector string
-	ResyncInterval time.Duration
-}
-
-type resourceCreator interface {
-	item() kruntime.Object
-	list() kruntime.Object
-}
-
-type podCreator struct {}
-func (*podCreator) item() kruntime.Object { return &kapi.Pod{} }
-func (*podCreator) list() kruntime.Object { return &kapi.PodList{} }
-
-type replicationControllerCreator struct {}
-func (*replicationControllerCreator) item() kruntime.Object { return &kapi.ReplicationController{} }
-func (*replicationControllerCreator) list() kruntime.Object { return &kapi.ReplicationControllerList{} }
-
-type serviceCreator struct {}
-func (*serviceCreator) item() kruntime.Object { return &kapi.Service{} }
-func (*serviceCreator) list() kruntime.Object { return &kapi.ServiceList{} }
-
-var resourceCreatorMap = map[string]resourceCreator {
-	"pods": &podCreator{},
-	"replicationcontrollers": &replicationControllerCreator{},
-	"services": &serviceCreator{},
-}
-
-func copyHeader(hIn http.Header) http.Header {
+// CopyHeader returns a deep copy of hIn.
+func CopyHeader(hIn http.Header) http.Header {
 	hOut := make(http.Header, len(hIn))
 	for k, vv := range hIn {
 		vv2 := make([]string, len(vv))
@@ -141,275 +96,80 @@ func NewClient(config *ClientConfig) (*Client, error) {
 		return nil, fmt.Errorf("invalid url scheme: '%s'", scheme)
 	}
 
-	secure := scheme == "https"
-	if secure && client.config.CaCertificate != nil {
-		// Create CA certificate pool
-		pool := x509.NewCertPool()
-		if ok := pool.AppendCertsFromPEM([]byte(config.CaCertificate)); !ok {
-			return nil, fmt.Errorf("unable to load CA certificate")
-		}
-
-		// Setup TLS config
-		client.tls = &tls.Config{RootCAs: pool}
-	}
-
-	// Load authentication parameters depending on the type
-	switch auth := client.config.Auth.(type) {
-	case *ClientCertificateAuth:
-		if !secure {
-			return nil, fmt.Errorf("client certificate requires using a secure endpoint")
-		}
-
-		cert, err := tls.X509KeyPair(auth.ClientCertificate, auth.ClientKey)
-		if err != nil {
-			return nil, fmt.Errorf("x509 client key pair could not be generated: %v", err)
-		}
-		client.tls.Certificates = []tls.Certificate{cert}
-	case *TokenAuth:
-		client.reqHeader = http.Header {
-			"Authorization": { fmt.Sprintf("Bearer %s", auth.Token) },
-		}
-	case *UsernameAndPasswordAuth:
-		encodedAuth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprint("%s:%s", auth.Username, auth.Password)))
-		client.reqHeader = http.Header {
-			"Authorization": { fmt.Sprintf("Basic %s", encodedAuth) },
-		}
-	default:
-		return nil, fmt.Errorf("unknown auth type: %v", auth)
-	}
-
-	if client.tls != nil {
-		client.tls.BuildNameToCertificate()
-	}
-
-	client.baseURL = fmt.Sprintf("%s/api/v1", url.Host)
-
-	return client, nil
-}
-
-func (c *Client) NewInformer(config *InformerConfig, recvChan chan<- interface{},
-                             stopChan <-chan struct{}, doneChan chan bool, errChan chan error) (*Informer, error) {
-	// Check if a channel was provided
-	if recvChan == nil {
-		return nil, fmt.Errorf("no recv chan was provided")
-	}
-
-	// Use POD_NAMESPACE as default value or fallback to "default"
-	namespace := config.Namespace
-	if namespace == "" {
-		namespace = os.Getenv("POD_NAMESPACE")
-		if namespace == "" {
-			namespace = "default"
-		}
-	}
-
-	// HTTP Client
-	httpURL := c.getResourcesURL("http", namespace, config.Resource, false)
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: c.tls,
-		},
+	client.secure = scheme == "https"
+	if _, ok := config.Auth.(*ClientCertificateAuth); ok && !client.secure {
+		return nil, fmt.Errorf("client certificate requires using a secure endpoint")
 	}
 
-	httpReq, err := http.NewRequest("GET", httpURL, nil)
+	// Load credentials
+	credentials, err := newCredentialProvider(config, client.secure)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: GET %s : %v", httpURL, err)
+		return nil, err
 	}
-	httpReq.Header = copyHeader(c.reqHeader)
+	client.credentials = credentials
 
-	// WebSocket Dialer
-	wsURL := c.getResourcesURL("ws", namespace, config.Resource, true)
-	wsDialer := &websocket.Dialer{
-		Proxy: http.ProxyFromEnvironment,
-		TLSClientConfig: c.tls,
-	}
-	wsHeader := copyHeader(c.reqHeader)
-	wsHeader.Add("Origin", "http://localhost")
+	client.baseURL = url.Host
 
-	resourceCreator, ok := resourceCreatorMap[config.Resource]
-	if !ok {
-		return nil, fmt.Errorf("'%s' is not a valid resource type", config.Resource)
-	}
-
-	// Return informer
-	return &Informer{
-		httpClient, httpReq,
-		wsURL, wsDialer, wsHeader,
-		config,
-		resourceCreator,
-		recvChan,
-		stopChan, doneChan, errChan,
-	}, nil
+	return client, nil
 }
 
-func (c *Client) getResourcesURL(schemePrefix, namespace, resource string, watch bool) string {
+func (c *Client) getURL(schemePrefix, path string) string {
 	// define scheme based on TLS
 	scheme := schemePrefix
-	if c.tls != nil {
+	if c.secure {
 		scheme = fmt.Sprintf("%ss", schemePrefix)
 	}
-
-	// Add watch prefix if needed
-	watchPrefix := ""
-	if watch {
-		watchPrefix = "watch/"
-	}
-
-	// Return resources URL
-	return fmt.Sprintf("%s://%s/%snamespaces/%s/%s", scheme, c.baseURL, watchPrefix, namespace, resource)
+	return fmt.Sprintf("%s://%s%s", scheme, c.baseURL, path)
 }
 
-func (i *Informer) watch() {
-	const (
-		// Time allowed to write a message to the peer.
-		writeWait = 10 * time.Second
-		// Time allowed to read the next pong message from the peer.
-		pongWait = 10 * time.Second
-		// Send pings to peer with this period. Must be less than pongWait.
-		pingPeriod = (pongWait * 9) / 10
-	)
+func (c *Client) getResourcesURL(schemePrefix, groupVersion, namespace, resource string) string {
+	path := apiPath(groupVersion)
 
-	// write writes a message with the given message type and payload.
-	writeFn := func(ws *websocket.Conn, mt int, payload []byte) error {
-		ws.SetWriteDeadline(time.Now().Add(writeWait))
-		return ws.WriteMessage(mt, payload)
-	}
-
-	for {
-		ws, resp, err := i.wsDialer.Dial(i.wsURL, i.wsHeader)
-		if err != nil {
-			if err == websocket.ErrBadHandshake {
-				err = fmt.Errorf("handshake failed with status %d", resp.StatusCode)
-			}
-			i.notifyError(err)
-			continue
-		}
-
-		// TODO: Look which is the max resource limit in kubernetes (the json serialized one)
-		//ws.SetReadLimit(maxResourceSize)
-		ws.SetReadDeadline(time.Now().Add(pongWait))
-		ws.SetPongHandler(func(string) error {
-			ws.SetReadDeadline(time.Now().Add(pongWait)); return nil
-		})
-
-		// this routine pumps messages from the hub to the websocket connection.
-		go func() {
-			ticker := time.NewTicker(pingPeriod)
-			defer func() {
-				ticker.Stop()
-				ws.Close()
-			}()
-			for {
-				select {
-				case <-ticker.C:
-					if err := writeFn(ws, websocket.PingMessage, []byte{}); err != nil {
-						return
-					}
-				}
-			}
-		}()
-
-		L: for {
-			select {
-			case <-i.stopChan:
-				break
-			default:
-				v := i.rc.item()
-				we := &kapi.WatchEvent{Object:v}
-				if err := ws.ReadJSON(&we); err != nil {
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
-						i.notifyError(err)
-					}
-					break L
-				} else {
-					// notify watch event
-					i.notify(we)
-				}
-			}
-		}
+	// Return resources URL, cluster wide if no namespace was given
+	if namespace != "" {
+		path += "/namespaces/" + namespace
 	}
+	return c.getURL(schemePrefix, path + "/" + resource)
 }
 
-func (i *Informer) list() {
-	httpURL := i.httpReq.URL.String()
-	for {
-		res, err := ctxhttp.Do(context.Background(), i.httpClient, i.httpReq)
-		if err != nil {
-			i.notifyError(fmt.Errorf("failed to make request: GET %s: %v", httpURL, err))
-			continue
-		}
-
-		body, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
-		if err != nil {
-			i.notifyError(fmt.Errorf("failed to read request body for GET %s: %v", httpURL, err))
-			continue
-		}
-
-		if res.StatusCode != http.StatusOK {
-			i.notifyError(fmt.Errorf("http error %d GET %q: %s: %v", res.StatusCode, httpURL, string(body), err))
-			continue
-		}
-
-		v := i.rc.list()
-		if err := json.Unmarshal(body, &v); err != nil {
-			i.notifyError(fmt.Errorf("failed to decode list of pod resources: %v", err))
-			continue
-		}
+// Credentials returns the provider of the client credentials.
+func (c *Client) Credentials() *CredentialProvider {
+	return c.credentials
+}
 
-		// notify list
-		i.notify(v)
+// httpClient returns an HTTP client using the current credentials.
+func (c *Client) httpClient() *http.Client {
+	return &http.Client{Transport: c.credentials.current().transport}
+}
 
-		// wait until resync is required
-		select {
-		case <-i.stopChan:
-			break
-		case <-time.After(i.config.ResyncInterval):
-			continue
-		}
-	}
+// tlsConfig returns the TLS configuration of the current credentials, nil
+// for insecure endpoints.
+func (c *Client) tlsConfig() *tls.Config {
+	return c.credentials.current().tls
 }
 
-func (i *Informer) notify(v interface{}) {
-	// send but do not block for it
-	select {
-	case i.recvChan <- v:
-	default:
-		log.Warnf("unable to notify item, discarding it (%v)", v)
-	}
+// header returns the headers authenticating a request with the current
+// credentials.
+func (c *Client) header() http.Header {
+	return CopyHeader(c.credentials.current().header)
 }
 
-func (i *Informer) notifyError(err error) {
-	// send but do not block for it
-	select {
-	case i.errChan <- err:
-	default:
-		log.Warnf("unable to notify error, discarding it (%v)", err)
+// retryUnauthorized calls fn and, if it was rejected as unauthorized, calls
+// it again once if reloading the credentials changes them.
+func (c *Client) retryUnauthorized(fn func() error) error {
+	err := fn()
+	if !isUnauthorized(err) {
+		return err
 	}
 
-	// Prevent errors from consuming all resources.
-	time.Sleep(1 * time.Second)
+	changed, reloadErr := c.credentials.Reload()
+	if reloadErr != nil {
+		log.Warnf("unable to reload credentials: %v", reloadErr)
+		return err
+	}
+	if !changed {
+		return err
+	}
+	log.Infof("Credentials were rejected, retrying with the reloaded ones")
+	return fn()
 }
-
-func (i *Informer) Run() {
-	defer close(i.doneChan)
-
-	var wg sync.WaitGroup
-
-	// watch through websocket endpoint
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		i.watch()
-	}()
-
-	// list using http endpoint
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		i.list()
-	}()
-
-	// wait until both finished
-	wg.Wait()
-}
\ No newline at end of file
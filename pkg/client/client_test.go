@@ -0,0 +1,177 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// authServer answers /api recording the authentication of every request.
+type authServer struct {
+	*httptest.Server
+	authorization chan string
+	peer          chan string
+}
+
+func newAuthServer(requestClientCert bool) *authServer {
+	s := &authServer{authorization: make(chan string, 1), peer: make(chan string, 1)}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.authorization <- r.Header.Get("Authorization")
+		peer := ""
+		if len(r.TLS.PeerCertificates) > 0 {
+			peer = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		s.peer <- peer
+		fmt.Fprint(w, `{"versions":["v1"]}`)
+	}))
+	if requestClientCert {
+		s.Server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	}
+	s.StartTLS()
+	return s
+}
+
+// caCertificate returns the PEM certificate the server is signed with.
+func (s *authServer) caCertificate() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+}
+
+func (s *authServer) get(t *testing.T, config *ClientConfig) (authorization, peer string) {
+	config.MasterURL = s.URL
+	c, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := map[string]interface{}{}
+	if err := c.getJSON(context.Background(), "/api", &versions); err != nil {
+		t.Fatal(err)
+	}
+	return <-s.authorization, <-s.peer
+}
+
+func newClientCertificate(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestTokenAuth(t *testing.T) {
+	s := newAuthServer(false)
+	defer s.Close()
+
+	authorization, _ := s.get(t, &ClientConfig{Auth: &TokenAuth{Token: "secret"}, CaCertificate: s.caCertificate()})
+	if authorization != "Bearer secret" {
+		t.Errorf("got authorization '%s', expected 'Bearer secret'", authorization)
+	}
+}
+
+func TestUsernameAndPasswordAuth(t *testing.T) {
+	s := newAuthServer(false)
+	defer s.Close()
+
+	authorization, _ := s.get(t, &ClientConfig{Auth: &UsernameAndPasswordAuth{Username: "user", Password: "pass"}, CaCertificate: s.caCertificate()})
+	if !strings.HasPrefix(authorization, "Basic ") {
+		t.Fatalf("got authorization '%s', expected basic", authorization)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "user:pass" {
+		t.Errorf("got credentials '%s', expected 'user:pass'", decoded)
+	}
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	s := newAuthServer(true)
+	defer s.Close()
+	cert, key := newClientCertificate(t, "kubelistener")
+
+	authorization, peer := s.get(t, &ClientConfig{Auth: &ClientCertificateAuth{ClientCertificate: cert, ClientKey: key}, CaCertificate: s.caCertificate()})
+	if authorization != "" {
+		t.Errorf("got authorization '%s', expected none", authorization)
+	}
+	if peer != "kubelistener" {
+		t.Errorf("got client certificate '%s', expected 'kubelistener'", peer)
+	}
+
+	// without CA the system roots are used, there is no TLS config to fill
+	// with the certificate otherwise
+	if _, err := NewClient(&ClientConfig{MasterURL: s.URL, Auth: &ClientCertificateAuth{ClientCertificate: cert, ClientKey: key}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewClient(&ClientConfig{MasterURL: strings.Replace(s.URL, "https:", "http:", 1), Auth: &ClientCertificateAuth{ClientCertificate: cert, ClientKey: key}}); err == nil {
+		t.Error("expected client certificates to require a secure endpoint")
+	}
+}
+
+func TestCaCertificate(t *testing.T) {
+	s := newAuthServer(false)
+	defer s.Close()
+	other, _ := newClientCertificate(t, "other")
+
+	for _, test := range []struct {
+		name string
+		ca   []byte
+		ok   bool
+	}{
+		{"server CA", s.caCertificate(), true},
+		{"system roots", nil, false},
+		{"unrelated CA", other, false},
+	} {
+		c, err := NewClient(&ClientConfig{MasterURL: s.URL, CaCertificate: test.ca})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		versions := map[string]interface{}{}
+		err = c.getJSON(context.Background(), "/api", &versions)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: expected the server certificate to be rejected", test.name)
+		}
+		if err == nil {
+			<-s.authorization
+			<-s.peer
+		}
+	}
+
+	if _, err := NewClient(&ClientConfig{MasterURL: s.URL, CaCertificate: []byte("junk")}); err == nil {
+		t.Error("expected an invalid CA certificate to be rejected")
+	}
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/glerchundi/logrus"
+	"golang.org/x/net/context"
+)
+
+// CredentialProvider supplies the credentials of a Client. Those read from
+// files are reloaded when the files change, so rotated tokens and
+// certificates are picked up by new requests and dials without restarting.
+type CredentialProvider struct {
+	config *ClientConfig
+	secure bool
+
+	// serializes reloads, guards material
+	reloadMu sync.Mutex
+	material *credentialMaterial
+
+	mu    sync.RWMutex
+	creds *credentials
+}
+
+// credentialMaterial is the raw data credentials are built from.
+type credentialMaterial struct {
+	ca            []byte
+	cert          []byte
+	key           []byte
+	authorization string
+}
+
+// credentials authenticate every request, they are never modified but
+// replaced as a whole on reload.
+type credentials struct {
+	header    http.Header
+	tls       *tls.Config
+	transport *http.Transport
+}
+
+func newCredentialProvider(config *ClientConfig, secure bool) (*CredentialProvider, error) {
+	p := &CredentialProvider{config: config, secure: secure}
+	if _, err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload reads the credentials again and replaces them if they changed,
+// reporting whether they did. The current ones are kept on failure.
+func (p *CredentialProvider) Reload() (bool, error) {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	m, err := p.load()
+	if err != nil {
+		return false, err
+	}
+	if p.material != nil && reflect.DeepEqual(m, p.material) {
+		return false, nil
+	}
+
+	creds, err := p.build(m)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	old := p.creds
+	p.creds = creds
+	p.mu.Unlock()
+	p.material = m
+
+	if old != nil {
+		// in flight requests keep their connections
+		old.transport.CloseIdleConnections()
+		log.Infof("Reloaded client credentials")
+	}
+	return true, nil
+}
+
+// Watch reloads the credentials every interval until ctx is done.
+func (p *CredentialProvider) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := p.Reload(); err != nil {
+				log.Warnf("unable to reload credentials, keeping the current ones: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *CredentialProvider) current() *credentials {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.creds
+}
+
+func (p *CredentialProvider) load() (*credentialMaterial, error) {
+	m := &credentialMaterial{}
+
+	var err error
+	if m.ca, err = readCredential(p.config.CaCertificate, p.config.CaCertificateFile); err != nil {
+		return nil, fmt.Errorf("unable to read CA certificate: %v", err)
+	}
+
+	switch auth := p.config.Auth.(type) {
+	case *ClientCertificateAuth:
+		if m.cert, err = readCredential(auth.ClientCertificate, auth.ClientCertificateFile); err != nil {
+			return nil, fmt.Errorf("unable to read client certificate: %v", err)
+		}
+		if m.key, err = readCredential(auth.ClientKey, auth.ClientKeyFile); err != nil {
+			return nil, fmt.Errorf("unable to read client key: %v", err)
+		}
+	case *TokenAuth:
+		token, err := readCredential([]byte(auth.Token), auth.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read token: %v", err)
+		}
+		// a file caught while being rewritten must not replace a valid token
+		trimmed := strings.TrimSpace(string(token))
+		if trimmed == "" {
+			return nil, fmt.Errorf("token is empty")
+		}
+		m.authorization = fmt.Sprintf("Bearer %s", trimmed)
+	case *UsernameAndPasswordAuth:
+		password, err := readCredential([]byte(auth.Password), auth.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read password: %v", err)
+		}
+		encodedAuth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", auth.Username, strings.TrimRight(string(password), "\r\n"))))
+		m.authorization = fmt.Sprintf("Basic %s", encodedAuth)
+	case nil:
+		// anonymous
+	default:
+		return nil, fmt.Errorf("unknown auth type: %v", auth)
+	}
+
+	return m, nil
+}
+
+func (p *CredentialProvider) build(m *credentialMaterial) (*credentials, error) {
+	creds := &credentials{header: make(http.Header)}
+	if m.authorization != "" {
+		creds.header.Set("Authorization", m.authorization)
+	}
+
+	// Setup TLS config, the system roots are used if no CA is given
+	if p.secure {
+		creds.tls = &tls.Config{}
+		if m.ca != nil {
+			pool := x509.NewCertPool()
+			if ok := pool.AppendCertsFromPEM(m.ca); !ok {
+				return nil, fmt.Errorf("unable to load CA certificate")
+			}
+			creds.tls.RootCAs = pool
+		}
+		if m.cert != nil || m.key != nil {
+			cert, err := tls.X509KeyPair(m.cert, m.key)
+			if err != nil {
+				return nil, fmt.Errorf("x509 client key pair could not be generated: %v", err)
+			}
+			creds.tls.Certificates = []tls.Certificate{cert}
+		}
+		creds.tls.BuildNameToCertificate()
+	}
+
+	creds.transport = &http.Transport{TLSClientConfig: creds.tls}
+	return creds, nil
+}
+
+// readCredential returns the contents of file if set, data otherwise.
+func readCredential(data []byte, file string) ([]byte, error) {
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return data, nil
+}
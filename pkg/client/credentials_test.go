@@ -0,0 +1,156 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func newCredentialsDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "kubelistener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func writeCredential(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadToken(t *testing.T) {
+	dir, cleanup := newCredentialsDir(t)
+	defer cleanup()
+	tokenFile := filepath.Join(dir, "token")
+	writeCredential(t, tokenFile, "one\n")
+
+	p, err := newCredentialProvider(&ClientConfig{Auth: &TokenAuth{TokenFile: tokenFile}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		token         string
+		changed       bool
+		err           bool
+		authorization string
+	}{
+		{"one", false, false, "Bearer one"},
+		{"two\n", true, false, "Bearer two"},
+		// an empty file keeps the current token
+		{"", false, true, "Bearer two"},
+		{" \n", false, true, "Bearer two"},
+		{"three", true, false, "Bearer three"},
+	} {
+		writeCredential(t, tokenFile, test.token)
+		changed, err := p.Reload()
+		if (err != nil) != test.err {
+			t.Errorf("%q: got error %v", test.token, err)
+		}
+		if changed != test.changed {
+			t.Errorf("%q: got changed %t, expected %t", test.token, changed, test.changed)
+		}
+		if authorization := p.current().header.Get("Authorization"); authorization != test.authorization {
+			t.Errorf("%q: got authorization '%s', expected '%s'", test.token, authorization, test.authorization)
+		}
+	}
+
+	if _, err := newCredentialProvider(&ClientConfig{Auth: &TokenAuth{}}, false); err == nil {
+		t.Error("expected an empty token to be rejected")
+	}
+}
+
+func TestReloadClientCertificate(t *testing.T) {
+	s := newAuthServer(true)
+	defer s.Close()
+	dir, cleanup := newCredentialsDir(t)
+	defer cleanup()
+
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writeClientCertificate := func(cn string) {
+		cert, key := newClientCertificate(t, cn)
+		writeCredential(t, certFile, string(cert))
+		writeCredential(t, keyFile, string(key))
+	}
+	writeClientCertificate("one")
+
+	c, err := NewClient(&ClientConfig{MasterURL: s.URL, Auth: &ClientCertificateAuth{ClientCertificateFile: certFile, ClientKeyFile: keyFile}, CaCertificate: s.caCertificate()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := func() string {
+		versions := map[string]interface{}{}
+		if err := c.getJSON(context.Background(), "/api", &versions); err != nil {
+			t.Fatal(err)
+		}
+		<-s.authorization
+		return <-s.peer
+	}
+	if cn := peer(); cn != "one" {
+		t.Fatalf("got client certificate '%s', expected 'one'", cn)
+	}
+
+	writeClientCertificate("two")
+	if changed, err := c.Credentials().Reload(); err != nil || !changed {
+		t.Fatalf("got changed %t (%v), expected the certificate to be reloaded", changed, err)
+	}
+	if cn := peer(); cn != "two" {
+		t.Errorf("got client certificate '%s' after reloading, expected 'two'", cn)
+	}
+}
+
+func TestRetryUnauthorized(t *testing.T) {
+	dir, cleanup := newCredentialsDir(t)
+	defer cleanup()
+	tokenFile := filepath.Join(dir, "token")
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"versions":["v1"]}`))
+	}))
+	defer s.Close()
+
+	for _, test := range []struct {
+		rotated  string
+		requests int
+		ok       bool
+	}{
+		// retried once the rotated token is read
+		{"valid", 2, true},
+		// not retried if the token did not change
+		{"expired", 1, false},
+		{"", 1, false},
+	} {
+		writeCredential(t, tokenFile, "expired")
+		c, err := NewClient(&ClientConfig{MasterURL: s.URL, Auth: &TokenAuth{TokenFile: tokenFile}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeCredential(t, tokenFile, test.rotated)
+
+		requests = 0
+		versions := map[string]interface{}{}
+		err = c.getJSON(context.Background(), "/api", &versions)
+		if test.ok && err != nil {
+			t.Errorf("%q: %v", test.rotated, err)
+		}
+		if !test.ok && !isUnauthorized(err) {
+			t.Errorf("%q: got %v, expected the request to be unauthorized", test.rotated, err)
+		}
+		if requests != test.requests {
+			t.Errorf("%q: got %d requests, expected %d", test.rotated, requests, test.requests)
+		}
+	}
+}
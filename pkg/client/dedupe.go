@@ -0,0 +1,64 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/glerchundi/kubelistener/pkg/client/fieldpath"
+	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+// semanticallyEqual tells whether old and obj only differ in fields updated
+// without the object really changing: the resource version, the self link,
+// timestamps within the status and the ignored paths.
+func semanticallyEqual(ignored []fieldpath.Path, old, obj kruntime.Object) (bool, error) {
+	oldDoc, err := semanticDocument(ignored, old)
+	if err != nil {
+		return false, err
+	}
+	doc, err := semanticDocument(ignored, obj)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(oldDoc, doc), nil
+}
+
+// semanticDocument returns obj as decoded from its JSON representation
+// without the fields semanticallyEqual ignores.
+func semanticDocument(ignored []fieldpath.Path, obj kruntime.Object) (interface{}, error) {
+	doc, err := ToDocument(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, ok := doc.(map[string]interface{}); ok {
+		if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+			delete(metadata, "resourceVersion")
+			delete(metadata, "selfLink")
+		}
+		stripTimestamps(m["status"])
+	}
+	for _, p := range ignored {
+		doc = p.Delete(doc)
+	}
+	return doc, nil
+}
+
+// stripTimestamps removes the fields named after a time, e.g.
+// 'lastHeartbeatTime' or 'lastTimestamp', at any depth of v.
+func stripTimestamps(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if strings.HasSuffix(k, "Time") || strings.HasSuffix(k, "Timestamp") {
+				delete(v, k)
+				continue
+			}
+			stripTimestamps(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			stripTimestamps(child)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+	log "github.com/glerchundi/logrus"
+	"github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+)
+
+// Discovery holds the resources served by the API server.
+type Discovery struct {
+	// group versions in lookup order, the legacy one and then the
+	// preferred version of every group before the rest
+	groupVersions []string
+	resources map[string][]unversioned.APIResource
+}
+
+// Discover queries /api, /apis and the resources of every group version,
+// skipping the ones failing. The result is cached for the lifetime of the
+// client.
+func (c *Client) Discover(ctx context.Context) (*Discovery, error) {
+	c.discoveryMu.Lock()
+	defer c.discoveryMu.Unlock()
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+
+	d := &Discovery{resources: make(map[string][]unversioned.APIResource)}
+
+	// legacy API group
+	versions := &unversioned.APIVersions{}
+	if err := c.getJSON(ctx, "/api", versions); err != nil {
+		return nil, err
+	}
+	groupVersions := versions.Versions
+
+	// named API groups, not served by old servers
+	groups := &unversioned.APIGroupList{}
+	if err := c.getJSON(ctx, "/apis", groups); err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	for _, g := range groups.Groups {
+		if g.PreferredVersion.GroupVersion != "" {
+			groupVersions = append(groupVersions, g.PreferredVersion.GroupVersion)
+		}
+		for _, v := range g.Versions {
+			if v.GroupVersion != g.PreferredVersion.GroupVersion {
+				groupVersions = append(groupVersions, v.GroupVersion)
+			}
+		}
+	}
+
+	// a broken group version, e.g. an unavailable aggregated API, does not
+	// hide the rest
+	for _, gv := range groupVersions {
+		list := &unversioned.APIResourceList{}
+		if err := c.getJSON(ctx, apiPath(gv), list); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			log.Warnf("unable to discover resources of %s, skipping it: %v", gv, err)
+			continue
+		}
+		d.groupVersions = append(d.groupVersions, gv)
+		d.resources[gv] = list.APIResources
+	}
+
+	c.discovery = d
+	return d, nil
+}
+
+// Discovery returns the result of a previous Discover, nil if none.
+func (c *Client) Discovery() *Discovery {
+	c.discoveryMu.Lock()
+	defer c.discoveryMu.Unlock()
+	return c.discovery
+}
+
+// getJSON decodes the response to a GET of path into v, retrying once with
+// reloaded credentials if unauthorized.
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	return c.retryUnauthorized(func() error {
+		return c.getJSONOnce(ctx, path, v)
+	})
+}
+
+func (c *Client) getJSONOnce(ctx context.Context, path string, v interface{}) error {
+	httpURL := c.getURL("http", path)
+	req, err := http.NewRequest("GET", httpURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: GET %s : %v", httpURL, err)
+	}
+	req.Header = c.header()
+
+	res, err := ctxhttp.Do(ctx, c.httpClient(), req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: GET %s: %v", httpURL, err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read request body for GET %s: %v", httpURL, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return newStatusError(res, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode GET %s: %v", httpURL, err)
+	}
+	return nil
+}
+
+// ResolveResource returns the canonical name of a served resource given its
+// plural, singular, kind or short name, or 'group/version/resource'.
+func (d *Discovery) ResolveResource(name string) (string, error) {
+	rc, err := d.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return rc.name, nil
+}
+
+// ParseResources is like the package level ParseResources but only accepts
+// served resources.
+func (d *Discovery) ParseResources(spec string) ([]string, error) {
+	var all []string
+	for _, r := range d.resources["v1"] {
+		if _, ok := resourceCreatorMap[r.Name]; ok {
+			all = append(all, r.Name)
+		}
+	}
+	sort.Strings(all)
+	return parseResources(spec, all, d.ResolveResource)
+}
+
+func (d *Discovery) lookup(name string) (*resourceCreator, error) {
+	name = strings.ToLower(name)
+
+	// fully qualified
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		gv, resource := name[:i], name[i+1:]
+		for _, r := range d.resources[gv] {
+			if r.Name == resource {
+				return d.creator(gv, r), nil
+			}
+		}
+		return nil, d.notFound(name)
+	}
+
+	// the static aliases of the legacy resources are known by old servers
+	alias := resourceAliases[name]
+	for _, gv := range d.groupVersions {
+		for _, r := range d.resources[gv] {
+			if strings.Contains(r.Name, "/") {
+				// subresource
+				continue
+			}
+			if r.Name == name || r.SingularName == name || strings.ToLower(r.Kind) == name || contains(r.ShortNames, name) ||
+				(gv == "v1" && r.Name == alias) {
+				return d.creator(gv, r), nil
+			}
+		}
+	}
+	return nil, d.notFound(name)
+}
+
+func (d *Discovery) creator(gv string, r unversioned.APIResource) *resourceCreator {
+	if rc, ok := resourceCreatorMap[r.Name]; ok && gv == rc.groupVersion {
+		return rc
+	}
+	rc := newUnstructuredCreator(gv, r.Name, r.Namespaced)
+	rc.kind = r.Kind
+	rc.listOnly = len(r.Verbs) > 0 && !contains(r.Verbs, "watch")
+	return rc
+}
+
+func (d *Discovery) notFound(name string) error {
+	var valid []string
+	for _, gv := range d.groupVersions {
+		for _, r := range d.resources[gv] {
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			if rc, ok := resourceCreatorMap[r.Name]; ok && gv == rc.groupVersion {
+				valid = append(valid, r.Name)
+			} else {
+				valid = append(valid, gv+"/"+r.Name)
+			}
+		}
+	}
+	sort.Strings(valid)
+	return fmt.Errorf("the server doesn't have a resource type '%s', valid ones are: %s", name, strings.Join(valid, ", "))
+}
+
+// apiPath returns the path serving a group version.
+func apiPath(groupVersion string) string {
+	// legacy API group lives under /api, the rest under /apis
+	if strings.Contains(groupVersion, "/") {
+		return "/apis/" + groupVersion
+	}
+	return "/api/" + groupVersion
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDiscoverSkipsFailingGroupVersions(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"versions":["v1"]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"groups":[
+				{"name":"metrics.k8s.io","versions":[{"groupVersion":"metrics.k8s.io/v1beta1","version":"v1beta1"}],"preferredVersion":{"groupVersion":"metrics.k8s.io/v1beta1","version":"v1beta1"}},
+				{"name":"apps","versions":[{"groupVersion":"apps/v1","version":"v1"}],"preferredVersion":{"groupVersion":"apps/v1","version":"v1"}}
+			]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"groupVersion":"v1","resources":[{"name":"services","namespaced":true,"kind":"Service"}]}`)
+		case "/apis/apps/v1":
+			fmt.Fprint(w, `{"groupVersion":"apps/v1","resources":[{"name":"deployments","namespaced":true,"kind":"Deployment"}]}`)
+		default:
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewClient(&ClientConfig{MasterURL: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := c.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"services": "services", "deployments": "apps/v1/deployments"} {
+		got, err := d.ResolveResource(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("%s: resolved to %s, expected %s", name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package fieldpath evaluates paths to fields of JSON documents, written
+// either dotted ('spec.ports') or JSONPath style ('{.spec.ports[0]}').
+package fieldpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed field path.
+type Path struct {
+	raw      string
+	segments []segment
+}
+
+// segment is either a map key or, if index is not negative, a list index.
+type segment struct {
+	key   string
+	index int
+}
+
+// Parse parses a path made of dot separated keys, indexes ('[0]') and
+// quoted keys ('['app.example.com/name']'). The JSONPath root ('$' or a
+// leading dot) and braces are optional.
+func Parse(path string) (Path, error) {
+	p := Path{raw: path}
+
+	s := strings.TrimSpace(path)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(s, ".")
+	if s == "" {
+		return p, fmt.Errorf("empty field path '%s'", path)
+	}
+
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "["):
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return p, fmt.Errorf("invalid field path '%s': missing ']'", path)
+			}
+			inner := s[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p.segments = append(p.segments, segment{key: inner[1 : len(inner)-1], index: -1})
+			} else if i, err := strconv.Atoi(inner); err == nil && i >= 0 {
+				p.segments = append(p.segments, segment{index: i})
+			} else {
+				return p, fmt.Errorf("invalid field path '%s': bad subscript '%s'", path, inner)
+			}
+			s = s[end+1:]
+		case strings.HasPrefix(s, "."):
+			s = s[1:]
+			if s == "" || s[0] == '.' {
+				return p, fmt.Errorf("invalid field path '%s': empty key", path)
+			}
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			p.segments = append(p.segments, segment{key: s[:end], index: -1})
+			s = s[end:]
+		}
+	}
+
+	return p, nil
+}
+
+func (p Path) String() string {
+	return p.raw
+}
+
+// Get returns the value at p in a document decoded from JSON into an
+// interface{}, and whether it exists.
+func (p Path) Get(doc interface{}) (interface{}, bool) {
+	v := doc
+	for _, seg := range p.segments {
+		if seg.index < 0 {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[seg.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		l, ok := v.([]interface{})
+		if !ok || seg.index >= len(l) {
+			return nil, false
+		}
+		v = l[seg.index]
+	}
+	return v, true
+}
+
+// Delete removes the value at p, if any, from a document decoded from JSON
+// into an interface{}. The document is modified in place and returned, list
+// elements are removed by reslicing so the result must be used.
+func (p Path) Delete(doc interface{}) interface{} {
+	return deleteSegments(doc, p.segments)
+}
+
+func deleteSegments(v interface{}, segments []segment) interface{} {
+	if len(segments) == 0 {
+		return v
+	}
+	seg, rest := segments[0], segments[1:]
+
+	if seg.index < 0 {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		child, ok := m[seg.key]
+		if !ok {
+			return v
+		}
+		if len(rest) == 0 {
+			delete(m, seg.key)
+		} else {
+			m[seg.key] = deleteSegments(child, rest)
+		}
+		return m
+	}
+
+	l, ok := v.([]interface{})
+	if !ok || seg.index >= len(l) {
+		return v
+	}
+	if len(rest) == 0 {
+		return append(l[:seg.index:seg.index], l[seg.index+1:]...)
+	}
+	l[seg.index] = deleteSegments(l[seg.index], rest)
+	return l
+}
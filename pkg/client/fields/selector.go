@@ -0,0 +1,78 @@
+// Package fields parses field selectors as understood by the API server.
+package fields
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operator is the relation a Requirement sets between a field and a value.
+type Operator string
+
+const (
+	Equals       Operator = "="
+	DoubleEquals Operator = "=="
+	NotEquals    Operator = "!="
+)
+
+// Requirement is a single condition of a selector, e.g.
+// 'spec.nodeName=node-1'.
+type Requirement struct {
+	Field    string
+	Operator Operator
+	Value    string
+}
+
+// Selector is a list of requirements which must all be met.
+type Selector []Requirement
+
+// Parse parses a comma separated list of 'field=value', 'field==value' or
+// 'field!=value' requirements.
+func Parse(selector string) (Selector, error) {
+	s := Selector{}
+	if strings.TrimSpace(selector) == "" {
+		return s, nil
+	}
+
+	for _, term := range strings.Split(selector, ",") {
+		r, err := parseRequirement(strings.TrimSpace(term))
+		if err != nil {
+			return nil, fmt.Errorf("invalid field selector '%s': %v", selector, err)
+		}
+		s = append(s, r)
+	}
+	return s, nil
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	for _, op := range []Operator{NotEquals, DoubleEquals, Equals} {
+		if i := strings.Index(term, string(op)); i >= 0 {
+			r := Requirement{
+				Field:    strings.TrimSpace(term[:i]),
+				Operator: op,
+				Value:    strings.TrimSpace(term[i+len(op):]),
+			}
+			if r.Field == "" {
+				return r, fmt.Errorf("'%s' has no field", term)
+			}
+			if strings.ContainsAny(r.Field, " =!") {
+				return r, fmt.Errorf("invalid field '%s'", r.Field)
+			}
+			return r, nil
+		}
+	}
+	return Requirement{}, fmt.Errorf("'%s' is not of the form 'field=value' or 'field!=value'", term)
+}
+
+// Empty reports whether s selects everything.
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+func (s Selector) String() string {
+	terms := make([]string, len(s))
+	for i, r := range s {
+		terms[i] = r.Field + string(r.Operator) + r.Value
+	}
+	return strings.Join(terms, ",")
+}
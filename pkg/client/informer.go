@@ -0,0 +1,714 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+	log "github.com/glerchundi/logrus"
+	"github.com/glerchundi/kubelistener/pkg/client/api/meta"
+	"github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	"github.com/glerchundi/kubelistener/pkg/client/fieldpath"
+	"github.com/glerchundi/kubelistener/pkg/client/fields"
+	"github.com/glerchundi/kubelistener/pkg/client/labels"
+	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+// Watches closed by the server sooner than this are retried with a backoff.
+const minWatchDuration = 5 * time.Second
+
+type Informer struct {
+	// no-op updates dropped, first to keep it 64-bit aligned for atomic
+	// operations
+	suppressed uint64
+	// derived config, credentials are taken from the client on every
+	// request as they may be reloaded
+	client *Client
+	httpURL string
+	wsURL string
+	// user-provided configuration
+	config *InformerConfig
+	// inter-routine comm.
+	rc *resourceCreator
+	selector labels.Selector
+	watchFields []fieldpath.Path
+	ignoreFields []fieldpath.Path
+	store *Store
+	queue *Queue
+	// last resource version seen, watches resume from it
+	resourceVersion string
+	// control flow channels
+	stopChan chan struct{}
+	stopOnce sync.Once
+	errChan chan error
+}
+
+type InformerConfig struct {
+	Namespace string
+	// AllNamespaces watches the resource across the whole cluster,
+	// Namespace must be empty.
+	AllNamespaces bool
+	// NamespaceFilter, if set, discards objects from other namespaces
+	// before they are queued.
+	NamespaceFilter *NamespaceFilter
+	Resource string
+	// Selector and FieldSelector restrict the watched objects by their
+	// labels and fields, e.g. 'spec.nodeName=node-1'.
+	Selector string
+	FieldSelector string
+	// ClientSelector is a label selector evaluated locally against the
+	// previous and new state of every object, objects starting or stopping
+	// to match it are reported as ENTERED and LEFT.
+	ClientSelector string
+	// WatchFields, if set, restricts MODIFIED events to the ones changing
+	// any of these paths, e.g. 'spec.ports' or '{.metadata.labels}'.
+	WatchFields []string
+	// Dedupe drops MODIFIED events not really changing the object, only
+	// its resource version, self link, status timestamps or any of the
+	// IgnoreFields paths.
+	Dedupe bool
+	IgnoreFields []string
+	ResyncInterval time.Duration
+	Backoff *BackoffConfig
+	// ResourceVersion, if set, makes the initial list only prime the store
+	// and resumes watching from it.
+	ResourceVersion string
+	// Bookmarks queues a Bookmark for the changes whose events are all
+	// filtered out, so the version they lead to can still be checkpointed.
+	Bookmarks bool
+	// PollInterval between lists of the resources not supporting watches.
+	PollInterval time.Duration
+}
+
+// rawWatchEvent defers decoding the object until its type is known, ERROR
+// events carry a Status instead of the watched resource.
+type rawWatchEvent struct {
+	Type kapi.EventType `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// StatusError is an error reported by the server through a Status object.
+type StatusError struct {
+	Status *unversioned.Status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s (reason: %s, code: %d)", e.Status.Message, e.Status.Reason, e.Status.Code)
+}
+
+// newStatusError builds an error from a failed response, decoding the Status
+// in body if present. A Retry-After header is honored as the retry delay.
+func newStatusError(res *http.Response, body []byte) *StatusError {
+	status := &unversioned.Status{}
+	if err := json.Unmarshal(body, status); err != nil || status.Kind != "Status" {
+		status = &unversioned.Status{
+			Status: unversioned.StatusFailure,
+			Message: fmt.Sprintf("http error %d: %s", res.StatusCode, string(body)),
+		}
+	}
+	if status.Code == 0 {
+		status.Code = res.StatusCode
+	}
+
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		if status.Details == nil {
+			status.Details = &unversioned.StatusDetails{}
+		}
+		if status.Details.RetryAfterSeconds == 0 {
+			status.Details.RetryAfterSeconds = seconds
+		}
+	}
+
+	return &StatusError{status}
+}
+
+// isExpired returns true if err means that the requested resource version
+// is too old to watch from and a new list is required.
+func isExpired(err error) bool {
+	se, ok := err.(*StatusError)
+	if !ok {
+		return false
+	}
+	return se.Status.Code == http.StatusGone ||
+		se.Status.Reason == unversioned.StatusReasonExpired ||
+		se.Status.Reason == unversioned.StatusReasonGone
+}
+
+func isNotFound(err error) bool {
+	se, ok := err.(*StatusError)
+	return ok && (se.Status.Code == http.StatusNotFound || se.Status.Reason == unversioned.StatusReasonNotFound)
+}
+
+// isUnauthorized returns true if err means the credentials were rejected.
+func isUnauthorized(err error) bool {
+	se, ok := err.(*StatusError)
+	return ok && se.Status.Code == http.StatusUnauthorized
+}
+
+func (c *Client) NewInformer(config *InformerConfig, queue *Queue, errChan chan error) (*Informer, error) {
+	// Check if a queue was provided
+	if queue == nil {
+		return nil, fmt.Errorf("no queue was provided")
+	}
+
+	if config.AllNamespaces && config.Namespace != "" {
+		return nil, fmt.Errorf("namespace '%s' can not be combined with all namespaces", config.Namespace)
+	}
+	if config.NamespaceFilter != nil {
+		if err := config.NamespaceFilter.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// prefer what the server said it serves
+	lookup := lookupResource
+	if d := c.Discovery(); d != nil {
+		lookup = d.lookup
+	}
+	resourceCreator, err := lookup(config.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	// refer to the resource by its canonical name from now on
+	canonical := *config
+	canonical.Resource = resourceCreator.name
+	config = &canonical
+
+	// Validate selectors before the server has to
+	if _, err := labels.Parse(config.Selector); err != nil {
+		return nil, err
+	}
+	if _, err := fields.Parse(config.FieldSelector); err != nil {
+		return nil, err
+	}
+	var clientSelector labels.Selector
+	if config.ClientSelector != "" {
+		if clientSelector, err = labels.Parse(config.ClientSelector); err != nil {
+			return nil, err
+		}
+	}
+	var watchFields []fieldpath.Path
+	for _, f := range config.WatchFields {
+		p, err := fieldpath.Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		watchFields = append(watchFields, p)
+	}
+	var ignoreFields []fieldpath.Path
+	for _, f := range config.IgnoreFields {
+		p, err := fieldpath.Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		ignoreFields = append(ignoreFields, p)
+	}
+
+	// Use POD_NAMESPACE as default value or fallback to "default", cluster
+	// scoped resources are never namespaced
+	namespace := config.Namespace
+	if config.AllNamespaces || !resourceCreator.namespaced {
+		namespace = kapi.NamespaceAll
+	} else if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+		if namespace == "" {
+			namespace = "default"
+		}
+	}
+
+	// HTTP Client
+	httpURL := c.getResourcesURL("http", resourceCreator.groupVersion, namespace, resourceCreator.resource)
+	if query := listQuery(&kapi.ListOptions{
+		LabelSelector: config.Selector,
+		FieldSelector: config.FieldSelector,
+	}); query != "" {
+		httpURL += "?" + query
+	}
+	if _, err := http.NewRequest("GET", httpURL, nil); err != nil {
+		return nil, fmt.Errorf("failed to create request: GET %s : %v", httpURL, err)
+	}
+
+	// WebSocket URL
+	wsURL := c.getResourcesURL("ws", resourceCreator.groupVersion, namespace, resourceCreator.resource)
+
+	// Return informer
+	return &Informer{
+		client: c,
+		httpURL: httpURL,
+		wsURL: wsURL,
+		config: config,
+		rc: resourceCreator,
+		selector: clientSelector,
+		watchFields: watchFields,
+		ignoreFields: ignoreFields,
+		store: NewStore(),
+		queue: queue,
+		stopChan: make(chan struct{}),
+		errChan: errChan,
+	}, nil
+}
+
+// watchURL returns the watch endpoint resuming from the last seen version.
+func (i *Informer) watchURL() string {
+	return i.wsURL + "?" + listQuery(&kapi.ListOptions{
+		LabelSelector: i.config.Selector,
+		FieldSelector: i.config.FieldSelector,
+		Watch: true,
+		ResourceVersion: i.resourceVersion,
+	})
+}
+
+// listQuery encodes opts as the query string of a list or watch request.
+func listQuery(opts *kapi.ListOptions) string {
+	q := url.Values{}
+	if opts.LabelSelector != "" {
+		q.Set("labelSelector", opts.LabelSelector)
+	}
+	if opts.FieldSelector != "" {
+		q.Set("fieldSelector", opts.FieldSelector)
+	}
+	if opts.ResourceVersion != "" {
+		q.Set("resourceVersion", opts.ResourceVersion)
+	}
+	if opts.Watch {
+		q.Set("watch", "true")
+	}
+	return q.Encode()
+}
+
+// dial connects to the websocket endpoint, giving up as soon as ctx is done.
+func (i *Informer) dial(ctx context.Context, wsURL string) (*websocket.Conn, *http.Response, error) {
+	dialed := make(chan struct{})
+	defer close(dialed)
+
+	d := websocket.Dialer{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: i.client.tlsConfig(),
+	}
+	d.NetDial = func(network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{Cancel: ctx.Done()}).Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		// abort the handshake too
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-dialed:
+			}
+		}()
+		return conn, nil
+	}
+
+	header := i.client.header()
+	header.Add("Origin", "http://localhost")
+	ws, resp, err := d.Dial(wsURL, header)
+	if err != nil && ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	return ws, resp, err
+}
+
+// watch streams changes since the last seen resource version until the
+// connection is closed, resync is due or ctx is done. An expired resource
+// version is reported as a StatusError.
+func (i *Informer) watch(ctx context.Context, resyncAt time.Time) error {
+	const (
+		// Time allowed to write a message to the peer.
+		writeWait = 10 * time.Second
+		// Time allowed to read the next pong message from the peer.
+		pongWait = 10 * time.Second
+		// Send pings to peer with this period. Must be less than pongWait.
+		pingPeriod = (pongWait * 9) / 10
+	)
+
+	// write writes a message with the given message type and payload.
+	writeFn := func(ws *websocket.Conn, mt int, payload []byte) error {
+		ws.SetWriteDeadline(time.Now().Add(writeWait))
+		return ws.WriteMessage(mt, payload)
+	}
+
+	wsURL := i.watchURL()
+	ws, resp, err := i.dial(ctx, wsURL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == websocket.ErrBadHandshake && resp != nil {
+			var body []byte
+			if resp.Body != nil {
+				body, _ = ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			se := newStatusError(resp, body)
+			if resp.StatusCode == http.StatusGone && se.Status.Reason == "" {
+				se.Status.Reason = unversioned.StatusReasonGone
+			}
+			return se
+		}
+		return fmt.Errorf("failed to watch %s: %v", wsURL, err)
+	}
+
+	// TODO: Look which is the max resource limit in kubernetes (the json serialized one)
+	//ws.SetReadLimit(maxResourceSize)
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		ws.SetReadDeadline(time.Now().Add(pongWait)); return nil
+	})
+
+	// wait for the helper routines before returning
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+		ws.Close()
+	}()
+
+	// this routine pumps pings to the websocket connection.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(pingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := writeFn(ws, websocket.PingMessage, []byte{}); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// this routine closes the connection once a resync is due or ctx is
+	// done, unblocking the reader.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resync := time.NewTimer(resyncAt.Sub(time.Now()))
+		defer resync.Stop()
+		select {
+		case <-resync.C:
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		ws.Close()
+	}()
+
+	for {
+		var raw rawWatchEvent
+		if err := ws.ReadJSON(&raw); err != nil {
+			if ctx.Err() != nil || !time.Now().Before(resyncAt) {
+				return nil
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return fmt.Errorf("failed to read watch event: %v", err)
+		}
+
+		if raw.Type == kapi.Error {
+			status := &unversioned.Status{}
+			if err := json.Unmarshal(raw.Object, status); err != nil {
+				return fmt.Errorf("failed to decode watch error: %v", err)
+			}
+			return &StatusError{status}
+		}
+
+		v := i.rc.item()
+		if err := json.Unmarshal(raw.Object, v); err != nil {
+			i.notifyError(fmt.Errorf("failed to decode %s watch event: %v", raw.Type, err))
+			continue
+		}
+
+		i.apply(ctx, &kapi.WatchEvent{Type: raw.Type, Object: v})
+	}
+}
+
+// list replaces the store contents with the current state of the resources
+// notifying the differences, and keeps the version to watch from.
+func (i *Informer) list(ctx context.Context) error {
+	v, rv, err := i.fetchList(ctx)
+	if err != nil {
+		return err
+	}
+
+	// reconcile the store with the list
+	events, err := i.store.Replace(v)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile list of %s: %v", i.config.Resource, err)
+	}
+	i.resourceVersion = rv
+	i.notifyAll(ctx, events, rv)
+
+	return nil
+}
+
+// prime fills the store with the current state of the resources without
+// notifying them, the watch keeps resuming from the configured version.
+func (i *Informer) prime(ctx context.Context) error {
+	v, _, err := i.fetchList(ctx)
+	if err != nil {
+		return err
+	}
+	if err := i.store.Prime(v); err != nil {
+		return fmt.Errorf("failed to prime store with list of %s: %v", i.config.Resource, err)
+	}
+	return nil
+}
+
+// fetchList returns the current list of the resources and its version.
+func (i *Informer) fetchList(ctx context.Context) (kruntime.Object, string, error) {
+	httpURL := i.httpURL
+	req, err := http.NewRequest("GET", httpURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: GET %s : %v", httpURL, err)
+	}
+	req.Header = i.client.header()
+
+	res, err := ctxhttp.Do(ctx, i.client.httpClient(), req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to make request: GET %s: %v", httpURL, err)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read request body for GET %s: %v", httpURL, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", newStatusError(res, body)
+	}
+
+	v := i.rc.list()
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, "", fmt.Errorf("failed to decode list of %s: %v", i.config.Resource, err)
+	}
+
+	m, err := meta.ListAccessor(v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return v, m.GetResourceVersion(), nil
+}
+
+// apply updates the store with a watch event notifying the resulting changes.
+func (i *Informer) apply(ctx context.Context, we *kapi.WatchEvent) {
+	events, err := i.store.Apply(we)
+	if err != nil {
+		i.notifyError(fmt.Errorf("failed to apply watch event: %v", err))
+		return
+	}
+	if rv := resourceVersion(we.Object); rv != "" {
+		i.resourceVersion = rv
+	}
+	i.notifyAll(ctx, events, i.resourceVersion)
+}
+
+// Store returns the local cache of the watched resources.
+func (i *Informer) Store() *Store {
+	return i.store
+}
+
+// Suppressed returns the number of no-op updates dropped so far.
+func (i *Informer) Suppressed() uint64 {
+	return atomic.LoadUint64(&i.suppressed)
+}
+
+// notifyAll queues the events not filtered out, in order, blocking or not
+// depending on the queue policy. The watch can only be resumed from
+// resumeVersion once all of them have been handled, so just the last one
+// carries it, or a Bookmark if there is none.
+func (i *Informer) notifyAll(ctx context.Context, events []*Event, resumeVersion string) {
+	var queued []*Event
+	for _, e := range events {
+		if e = i.filter(e); e != nil {
+			queued = append(queued, e)
+		}
+	}
+	if len(queued) == 0 {
+		if !i.config.Bookmarks || resumeVersion == "" {
+			return
+		}
+		queued = append(queued, &Event{Type: Bookmark, Resource: i.config.Resource, Kind: i.rc.kind})
+	}
+	queued[len(queued)-1].ResumeVersion = resumeVersion
+
+	for _, e := range queued {
+		if err := i.queue.Put(ctx, e); err != nil && ctx.Err() == nil {
+			i.notifyError(fmt.Errorf("unable to queue %s event: %v", e.Type, err))
+		}
+	}
+}
+
+// filter returns the event to notify for e, nil if dropped. Objects from
+// filtered out namespaces or not selected by the client side selector are
+// dropped, so are no-op updates if deduping and modifications not touching
+// the watched fields.
+func (i *Informer) filter(e *Event) *Event {
+	if m, err := meta.Accessor(e.Object); err == nil && !i.config.NamespaceFilter.Matches(m.GetNamespace()) {
+		return nil
+	}
+	if e = selection(i.selector, e); e == nil {
+		return nil
+	}
+	if e.Type == kapi.Modified && e.Previous != nil && i.config.Dedupe {
+		equal, err := semanticallyEqual(i.ignoreFields, e.Previous, e.Object)
+		if err != nil {
+			i.notifyError(fmt.Errorf("unable to compare %s: %v", i.config.Resource, err))
+		} else if equal {
+			n := atomic.AddUint64(&i.suppressed, 1)
+			log.Debugf("suppressing no-op update of %s at resource version %s, %d suppressed so far", i.config.Resource, resourceVersion(e.Object), n)
+			return nil
+		}
+	}
+	if e.Type == kapi.Modified && e.Previous != nil && len(i.watchFields) > 0 {
+		changed, err := changedFields(i.watchFields, e.Previous, e.Object)
+		if err != nil {
+			i.notifyError(fmt.Errorf("unable to compare watched fields: %v", err))
+		} else if len(changed) == 0 {
+			return nil
+		}
+		e.Changed = changed
+	}
+
+	e.Resource = i.config.Resource
+	e.Kind = i.rc.kind
+	return e
+}
+
+func (i *Informer) notifyError(err error) {
+	// send but do not block for it
+	select {
+	case i.errChan <- err:
+	default:
+		log.Warnf("unable to notify error, discarding it (%v)", err)
+	}
+}
+
+// Run lists the resources and then watches them from the listed version,
+// resuming from the last seen one on reconnects. A relist happens every
+// resync interval or as soon as the watched version expires. If a resource
+// version was configured the first list only primes the store, without
+// notifying anything, and the watch resumes from it. Resources which can not
+// be watched are listed every poll interval instead. Lists and watches
+// rejected as unauthorized are retried at once if the credentials, reloaded
+// on the spot, changed. Watches closed by the server right after opening
+// them are retried with a backoff. It blocks until ctx is done or Stop is
+// called, once it returns nothing else will be sent to the informer
+// channels. It only fails if the resource is not served.
+func (i *Informer) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// translate Stop into a cancellation, the routine only exits once ctx
+	// is cancelled so do it before waiting for it
+	stopped := make(chan struct{})
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		select {
+		case <-i.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// failures are retried with independent delays
+	backoffConfig := i.config.Backoff
+	if backoffConfig == nil {
+		backoffConfig = NewBackoffConfig()
+	}
+	listBackoff := newBackoff("list "+i.config.Resource, backoffConfig)
+	watchBackoff := newBackoff("watch "+i.config.Resource, backoffConfig)
+
+	listOnly := i.rc.listOnly
+	pollInterval := i.config.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = time.Minute
+	}
+
+	resume := i.config.ResourceVersion != "" && !listOnly
+	if resume {
+		i.resourceVersion = i.config.ResourceVersion
+		log.Infof("Resuming %s watch from resource version %s", i.config.Resource, i.resourceVersion)
+	}
+
+	for ctx.Err() == nil {
+		list := i.list
+		if resume {
+			list = i.prime
+		}
+		if err := i.client.retryUnauthorized(func() error { return list(ctx) }); err != nil {
+			if isNotFound(err) {
+				// not served, retrying won't help
+				return fmt.Errorf("unable to list %s: %v", i.config.Resource, err)
+			}
+			if ctx.Err() == nil {
+				i.notifyError(err)
+				listBackoff.wait(ctx, err)
+			}
+			continue
+		}
+		resume = false
+
+		if listOnly {
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		resyncAt := time.Now().Add(i.config.ResyncInterval)
+		for ctx.Err() == nil && time.Now().Before(resyncAt) {
+			start := time.Now()
+			err := i.client.retryUnauthorized(func() error { return i.watch(ctx, resyncAt) })
+			if err == nil {
+				if ctx.Err() != nil || !time.Now().Before(resyncAt) || time.Since(start) >= minWatchDuration {
+					continue
+				}
+				// closed right away, back off instead of reconnecting in a
+				// tight loop
+				log.Warnf("%s watch closed after %v", i.config.Resource, time.Since(start))
+				watchBackoff.wait(ctx, nil)
+				continue
+			}
+			if isExpired(err) {
+				log.Infof("%s at resource version %s: %v, relisting", i.config.Resource, i.resourceVersion, err)
+				break
+			}
+			i.notifyError(err)
+			watchBackoff.wait(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Stop makes Run return, it is safe to call it more than once.
+func (i *Informer) Stop() {
+	i.stopOnce.Do(func() { close(i.stopChan) })
+}
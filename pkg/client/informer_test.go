@@ -0,0 +1,113 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/context"
+)
+
+func TestInformerBacksOffClosedWatches(t *testing.T) {
+	var watches int32
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.RawQuery, "watch") {
+			// close every watch right after opening it
+			atomic.AddInt32(&watches, 1)
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			ws.Close()
+			return
+		}
+		switch r.URL.Path {
+		case "/api":
+			fmt.Fprint(w, `{"versions":["v1"]}`)
+		case "/api/v1":
+			fmt.Fprint(w, `{"groupVersion":"v1","resources":[{"name":"services","namespaced":true}]}`)
+		default:
+			fmt.Fprint(w, `{"kind":"ServiceList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[]}`)
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewClient(&ClientConfig{MasterURL: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := NewQueue(&QueueConfig{Size: 10, Policy: Block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := c.NewInformer(&InformerConfig{
+		Resource:       "services",
+		AllNamespaces:  true,
+		ResyncInterval: time.Hour,
+		Backoff:        &BackoffConfig{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, ResetAfter: time.Minute},
+	}, queue, make(chan error, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := i.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&watches); n > 10 {
+		t.Errorf("watch reopened %d times in a second, expected a backoff", n)
+	}
+}
+
+func TestNotifyAllResumeVersion(t *testing.T) {
+	queue, err := NewQueue(&QueueConfig{Size: 10, Policy: Block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := lookupResource("services")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &Informer{
+		config: &InformerConfig{Resource: "services", NamespaceFilter: &NamespaceFilter{Exclude: []string{"kube-system"}}, Bookmarks: true},
+		rc:     rc,
+		queue:  queue,
+	}
+	hidden := newService("hidden", "3", "9")
+	hidden.Namespace = "kube-system"
+
+	// the last event is filtered out, the one before carries the version
+	i.notifyAll(context.Background(), []*Event{
+		{Type: kapi.Added, Object: newService("a", "1", "7")},
+		{Type: kapi.Added, Object: newService("b", "2", "8")},
+		{Type: kapi.Added, Object: hidden},
+	}, "9")
+	for _, expected := range []string{"", "9"} {
+		e := <-queue.C()
+		if e.ResumeVersion != expected {
+			t.Errorf("got %s %s resuming from '%s', expected '%s'", e.Type, e.Object.(*kapi.Service).Name, e.ResumeVersion, expected)
+		}
+	}
+
+	// every event is filtered out, a bookmark carries it
+	i.notifyAll(context.Background(), []*Event{{Type: kapi.Modified, Object: hidden}}, "10")
+	if e := <-queue.C(); e.Type != Bookmark || e.Resource != "services" || e.ResumeVersion != "10" {
+		t.Errorf("got %s of %s resuming from '%s', expected a services bookmark resuming from '10'", e.Type, e.Resource, e.ResumeVersion)
+	}
+
+	// unless disabled
+	i.config.Bookmarks = false
+	i.notifyAll(context.Background(), []*Event{{Type: kapi.Modified, Object: hidden}}, "11")
+	if queue.Len() != 0 {
+		t.Errorf("got %d queued events, expected none", queue.Len())
+	}
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// kubeConfig is the subset of the kubectl configuration file needed to
+// reach a cluster.
+type kubeConfig struct {
+	Clusters       []namedCluster  `yaml:"clusters"`
+	Users          []namedAuthInfo `yaml:"users"`
+	Contexts       []namedContext  `yaml:"contexts"`
+	CurrentContext string          `yaml:"current-context"`
+}
+
+type namedCluster struct {
+	Name    string      `yaml:"name"`
+	Cluster kubeCluster `yaml:"cluster"`
+}
+
+type kubeCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+}
+
+type namedAuthInfo struct {
+	Name     string   `yaml:"name"`
+	AuthInfo authInfo `yaml:"user"`
+}
+
+type authInfo struct {
+	ClientCertificate     string `yaml:"client-certificate"`
+	ClientCertificateData string `yaml:"client-certificate-data"`
+	ClientKey             string `yaml:"client-key"`
+	ClientKeyData         string `yaml:"client-key-data"`
+	Token                 string `yaml:"token"`
+	TokenFile             string `yaml:"tokenFile"`
+	Username              string `yaml:"username"`
+	Password              string `yaml:"password"`
+}
+
+type namedContext struct {
+	Name    string      `yaml:"name"`
+	Context kubeContext `yaml:"context"`
+}
+
+type kubeContext struct {
+	Cluster   string `yaml:"cluster"`
+	AuthInfo  string `yaml:"user"`
+	Namespace string `yaml:"namespace"`
+}
+
+// LoadKubeConfig reads a kubeconfig file and returns the configuration of
+// the client described by contextName, or by its current context if empty,
+// along with the namespace the context defaults to. Relative file references
+// are resolved against the directory of the file and, unlike inline data,
+// reloaded by the client when they change.
+func LoadKubeConfig(path, contextName string) (*ClientConfig, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read kubeconfig: %v", err)
+	}
+
+	kc := &kubeConfig{}
+	if err := yaml.Unmarshal(data, kc); err != nil {
+		return nil, "", fmt.Errorf("unable to parse kubeconfig %s: %v", path, err)
+	}
+
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	if contextName == "" {
+		return nil, "", fmt.Errorf("kubeconfig %s has no current context, one must be given", path)
+	}
+
+	var ctx *kubeContext
+	for i := range kc.Contexts {
+		if kc.Contexts[i].Name == contextName {
+			ctx = &kc.Contexts[i].Context
+			break
+		}
+	}
+	if ctx == nil {
+		return nil, "", fmt.Errorf("context '%s' not found in kubeconfig %s", contextName, path)
+	}
+
+	var c *kubeCluster
+	for i := range kc.Clusters {
+		if kc.Clusters[i].Name == ctx.Cluster {
+			c = &kc.Clusters[i].Cluster
+			break
+		}
+	}
+	if c == nil {
+		return nil, "", fmt.Errorf("cluster '%s' of context '%s' not found in kubeconfig %s", ctx.Cluster, contextName, path)
+	}
+
+	// contexts without user are anonymous
+	user := &authInfo{}
+	if ctx.AuthInfo != "" {
+		user = nil
+		for i := range kc.Users {
+			if kc.Users[i].Name == ctx.AuthInfo {
+				user = &kc.Users[i].AuthInfo
+				break
+			}
+		}
+		if user == nil {
+			return nil, "", fmt.Errorf("user '%s' of context '%s' not found in kubeconfig %s", ctx.AuthInfo, contextName, path)
+		}
+	}
+
+	dir := filepath.Dir(path)
+	config := &ClientConfig{MasterURL: c.Server}
+	if c.CertificateAuthorityData != "" {
+		if config.CaCertificate, err = decodeData(c.CertificateAuthorityData); err != nil {
+			return nil, "", fmt.Errorf("unable to load certificate authority of cluster '%s': %v", ctx.Cluster, err)
+		}
+	} else {
+		config.CaCertificateFile = resolvePath(dir, c.CertificateAuthority)
+	}
+	if config.Auth, err = user.auth(dir); err != nil {
+		return nil, "", fmt.Errorf("unable to load credentials of user '%s': %v", ctx.AuthInfo, err)
+	}
+
+	return config, ctx.Namespace, nil
+}
+
+// auth returns the credentials of the user, a client certificate takes
+// precedence over a token and the latter over basic authentication.
+func (u *authInfo) auth(dir string) (ClientAuth, error) {
+	hasCert := u.ClientCertificateData != "" || u.ClientCertificate != ""
+	hasKey := u.ClientKeyData != "" || u.ClientKey != ""
+	if hasCert || hasKey {
+		if !hasCert || !hasKey {
+			return nil, fmt.Errorf("client certificate and key must be given together")
+		}
+		auth := &ClientCertificateAuth{}
+		var err error
+		if u.ClientCertificateData != "" {
+			if auth.ClientCertificate, err = decodeData(u.ClientCertificateData); err != nil {
+				return nil, err
+			}
+		} else {
+			auth.ClientCertificateFile = resolvePath(dir, u.ClientCertificate)
+		}
+		if u.ClientKeyData != "" {
+			if auth.ClientKey, err = decodeData(u.ClientKeyData); err != nil {
+				return nil, err
+			}
+		} else {
+			auth.ClientKeyFile = resolvePath(dir, u.ClientKey)
+		}
+		return auth, nil
+	}
+
+	if u.Token != "" {
+		return &TokenAuth{Token: u.Token}, nil
+	}
+	if u.TokenFile != "" {
+		return &TokenAuth{TokenFile: resolvePath(dir, u.TokenFile)}, nil
+	}
+
+	if u.Username != "" || u.Password != "" {
+		return &UsernameAndPasswordAuth{Username: u.Username, Password: u.Password}, nil
+	}
+
+	return nil, nil
+}
+
+// decodeData decodes base64 encoded inline data.
+func decodeData(data string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 data: %v", err)
+	}
+	return decoded, nil
+}
+
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testKubeConfig = `
+current-context: token
+clusters:
+- name: files
+  cluster:
+    server: https://files.example.com
+    certificate-authority: ca.crt
+- name: inline
+  cluster:
+    server: https://inline.example.com
+    certificate-authority-data: Y2E=
+users:
+- name: token
+  user:
+    token: secret
+- name: token-file
+  user:
+    tokenFile: tokens/token
+- name: cert-files
+  user:
+    client-certificate: certs/client.crt
+    client-key: /etc/client.key
+- name: cert-inline
+  user:
+    client-certificate-data: Y2VydA==
+    client-key-data: a2V5
+- name: cert-only
+  user:
+    client-certificate: client.crt
+- name: basic
+  user:
+    username: admin
+    password: pass
+- name: bad-data
+  user:
+    client-certificate-data: '%%%'
+    client-key-data: a2V5
+contexts:
+- name: token
+  context:
+    cluster: inline
+    user: token
+    namespace: kube-system
+- name: token-file
+  context:
+    cluster: files
+    user: token-file
+- name: cert-files
+  context:
+    cluster: files
+    user: cert-files
+- name: cert-inline
+  context:
+    cluster: inline
+    user: cert-inline
+- name: cert-only
+  context:
+    cluster: files
+    user: cert-only
+- name: basic
+  context:
+    cluster: files
+    user: basic
+- name: anonymous
+  context:
+    cluster: files
+- name: bad-data
+  context:
+    cluster: files
+    user: bad-data
+- name: missing-cluster
+  context:
+    cluster: nowhere
+    user: token
+- name: missing-user
+  context:
+    cluster: files
+    user: nobody
+`
+
+func writeKubeConfig(t *testing.T, data string) (string, func()) {
+	dir, err := ioutil.TempDir("", "kubelistener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadKubeConfig(t *testing.T) {
+	path, cleanup := writeKubeConfig(t, testKubeConfig)
+	defer cleanup()
+	dir := filepath.Dir(path)
+
+	for _, test := range []struct {
+		context   string
+		config    *ClientConfig
+		namespace string
+		err       string
+	}{
+		// the current context is used by default
+		{"", &ClientConfig{MasterURL: "https://inline.example.com", CaCertificate: []byte("ca"), Auth: &TokenAuth{Token: "secret"}}, "kube-system", ""},
+		{"token-file", &ClientConfig{MasterURL: "https://files.example.com", CaCertificateFile: filepath.Join(dir, "ca.crt"), Auth: &TokenAuth{TokenFile: filepath.Join(dir, "tokens/token")}}, "", ""},
+		{"cert-files", &ClientConfig{MasterURL: "https://files.example.com", CaCertificateFile: filepath.Join(dir, "ca.crt"), Auth: &ClientCertificateAuth{ClientCertificateFile: filepath.Join(dir, "certs/client.crt"), ClientKeyFile: "/etc/client.key"}}, "", ""},
+		{"cert-inline", &ClientConfig{MasterURL: "https://inline.example.com", CaCertificate: []byte("ca"), Auth: &ClientCertificateAuth{ClientCertificate: []byte("cert"), ClientKey: []byte("key")}}, "", ""},
+		{"basic", &ClientConfig{MasterURL: "https://files.example.com", CaCertificateFile: filepath.Join(dir, "ca.crt"), Auth: &UsernameAndPasswordAuth{Username: "admin", Password: "pass"}}, "", ""},
+		{"anonymous", &ClientConfig{MasterURL: "https://files.example.com", CaCertificateFile: filepath.Join(dir, "ca.crt")}, "", ""},
+		{"cert-only", nil, "", "client certificate and key must be given together"},
+		{"bad-data", nil, "", "invalid base64 data"},
+		{"missing-cluster", nil, "", "cluster 'nowhere' of context 'missing-cluster' not found"},
+		{"missing-user", nil, "", "user 'nobody' of context 'missing-user' not found"},
+		{"missing", nil, "", "context 'missing' not found"},
+	} {
+		config, namespace, err := LoadKubeConfig(path, test.context)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("context '%s': got error %v, expected %q", test.context, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("context '%s': %v", test.context, err)
+			continue
+		}
+		if !reflect.DeepEqual(config, test.config) {
+			t.Errorf("context '%s': got %+v (%+v), expected %+v (%+v)", test.context, config, config.Auth, test.config, test.config.Auth)
+		}
+		if namespace != test.namespace {
+			t.Errorf("context '%s': got namespace %q, expected %q", test.context, namespace, test.namespace)
+		}
+	}
+}
+
+func TestLoadKubeConfigWithoutCurrentContext(t *testing.T) {
+	data := strings.Replace(testKubeConfig, "current-context: token", "", 1)
+	path, cleanup := writeKubeConfig(t, data)
+	defer cleanup()
+
+	if _, _, err := LoadKubeConfig(path, ""); err == nil || !strings.Contains(err.Error(), "no current context") {
+		t.Errorf("got error %v, expected the context to be required", err)
+	}
+}
@@ -0,0 +1,29 @@
+package labels
+
+import (
+	"sort"
+	"strings"
+)
+
+// Set is a map of labels, as found in the object metadata.
+type Set map[string]string
+
+// Has reports whether label is set.
+func (ls Set) Has(label string) bool {
+	_, ok := ls[label]
+	return ok
+}
+
+// Get returns the value of label, empty if not set.
+func (ls Set) Get(label string) string {
+	return ls[label]
+}
+
+func (ls Set) String() string {
+	pairs := make([]string, 0, len(ls))
+	for k, v := range ls {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
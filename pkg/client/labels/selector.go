@@ -0,0 +1,253 @@
+// Package labels parses label selectors as understood by the API server
+// and evaluates them locally.
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Operator is the relation a Requirement sets between a label and its
+// values.
+type Operator string
+
+const (
+	Equals       Operator = "="
+	DoubleEquals Operator = "=="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is a single condition of a selector, e.g. 'tier in (web)'.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	// Values is empty for Exists and DoesNotExist and has exactly one
+	// element for the equality operators.
+	Values []string
+}
+
+// Selector is a list of requirements which must all be met.
+type Selector []Requirement
+
+// Everything returns a selector matching every set of labels.
+func Everything() Selector {
+	return Selector{}
+}
+
+// Parse parses a comma separated list of requirements, both equality based
+// ('key=value', 'key==value', 'key!=value') and set based ('key in (a,b)',
+// 'key notin (a,b)', 'key', '!key').
+func Parse(selector string) (Selector, error) {
+	terms, err := splitTerms(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	s := Selector{}
+	for _, term := range terms {
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector '%s': %v", selector, err)
+		}
+		s = append(s, r)
+	}
+	return s, nil
+}
+
+// splitTerms splits s by the commas outside parentheses.
+func splitTerms(s string) ([]string, error) {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			if depth++; depth > 1 {
+				return nil, fmt.Errorf("invalid label selector '%s': nested parentheses", s)
+			}
+		case ')':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("invalid label selector '%s': unbalanced parentheses", s)
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid label selector '%s': unbalanced parentheses", s)
+	}
+	terms = append(terms, s[start:])
+
+	// an empty selector selects everything
+	if len(terms) == 1 && strings.TrimSpace(terms[0]) == "" {
+		return nil, nil
+	}
+	return terms, nil
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return Requirement{}, fmt.Errorf("empty requirement")
+	}
+
+	var r Requirement
+	switch {
+	case strings.HasPrefix(term, "!") && !strings.Contains(term, "="):
+		r = Requirement{Key: strings.TrimSpace(term[1:]), Operator: DoesNotExist}
+	case strings.Contains(term, "("):
+		open := strings.Index(term, "(")
+		if !strings.HasSuffix(term, ")") {
+			return r, fmt.Errorf("'%s' does not end with ')'", term)
+		}
+		fields := strings.Fields(term[:open])
+		if len(fields) != 2 || (fields[1] != string(In) && fields[1] != string(NotIn)) {
+			return r, fmt.Errorf("'%s' is not of the form 'key in (values)' or 'key notin (values)'", term)
+		}
+		r = Requirement{Key: fields[0], Operator: Operator(fields[1])}
+		if values := strings.TrimSpace(term[open+1 : len(term)-1]); values != "" {
+			for _, v := range strings.Split(values, ",") {
+				r.Values = append(r.Values, strings.TrimSpace(v))
+			}
+		}
+		sort.Strings(r.Values)
+	case strings.Contains(term, "!="):
+		r = equality(term, NotEquals)
+	case strings.Contains(term, "=="):
+		r = equality(term, DoubleEquals)
+	case strings.Contains(term, "="):
+		r = equality(term, Equals)
+	default:
+		r = Requirement{Key: term, Operator: Exists}
+	}
+
+	return r, r.validate()
+}
+
+func equality(term string, op Operator) Requirement {
+	parts := strings.SplitN(term, string(op), 2)
+	return Requirement{
+		Key:      strings.TrimSpace(parts[0]),
+		Operator: op,
+		Values:   []string{strings.TrimSpace(parts[1])},
+	}
+}
+
+var (
+	namePattern   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	prefixPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+func (r Requirement) validate() error {
+	if err := validateKey(r.Key); err != nil {
+		return err
+	}
+	if (r.Operator == In || r.Operator == NotIn) && len(r.Values) == 0 {
+		return fmt.Errorf("'%s' requires at least one value", r.Operator)
+	}
+	for _, v := range r.Values {
+		if err := validateValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateKey checks k is an optionally prefixed name, e.g.
+// 'example.com/name'.
+func validateKey(k string) error {
+	name := k
+	if i := strings.Index(k, "/"); i >= 0 {
+		prefix := k[:i]
+		name = k[i+1:]
+		if len(prefix) > 253 || !prefixPattern.MatchString(prefix) {
+			return fmt.Errorf("invalid label key prefix '%s'", prefix)
+		}
+	}
+	if len(name) > 63 || !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid label key '%s'", k)
+	}
+	return nil
+}
+
+func validateValue(v string) error {
+	if v == "" {
+		return nil
+	}
+	if len(v) > 63 || !namePattern.MatchString(v) {
+		return fmt.Errorf("invalid label value '%s'", v)
+	}
+	return nil
+}
+
+// Matches reports whether ls meets every requirement.
+func (s Selector) Matches(ls Set) bool {
+	for _, r := range s {
+		if !r.Matches(ls) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether ls meets r. Negative operators match when the
+// label is not set.
+func (r Requirement) Matches(ls Set) bool {
+	switch r.Operator {
+	case Equals, DoubleEquals:
+		return ls.Has(r.Key) && ls.Get(r.Key) == r.Values[0]
+	case NotEquals:
+		return !ls.Has(r.Key) || ls.Get(r.Key) != r.Values[0]
+	case In:
+		return ls.Has(r.Key) && r.hasValue(ls.Get(r.Key))
+	case NotIn:
+		return !ls.Has(r.Key) || !r.hasValue(ls.Get(r.Key))
+	case Exists:
+		return ls.Has(r.Key)
+	case DoesNotExist:
+		return !ls.Has(r.Key)
+	}
+	return false
+}
+
+func (r Requirement) hasValue(value string) bool {
+	for _, v := range r.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether s selects everything.
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+func (s Selector) String() string {
+	terms := make([]string, len(s))
+	for i, r := range s {
+		terms[i] = r.String()
+	}
+	return strings.Join(terms, ",")
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case Exists:
+		return r.Key
+	case DoesNotExist:
+		return "!" + r.Key
+	case In, NotIn:
+		return r.Key + " " + string(r.Operator) + " (" + strings.Join(r.Values, ",") + ")"
+	}
+	return r.Key + string(r.Operator) + r.Values[0]
+}
@@ -0,0 +1,88 @@
+package labels
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAndMatch(t *testing.T) {
+	for _, test := range []struct {
+		selector string
+		labels   Set
+		matches  bool
+	}{
+		{"", Set{"tier": "web"}, true},
+		{"tier=web", Set{"tier": "web"}, true},
+		{"tier=web", Set{"tier": "db"}, false},
+		{"tier=web", Set{}, false},
+		{"tier==web", Set{"tier": "web"}, true},
+		{"tier==web", Set{"tier": "db"}, false},
+		{"tier!=web", Set{"tier": "db"}, true},
+		{"tier!=web", Set{}, true},
+		{"tier!=web", Set{"tier": "web"}, false},
+		{"tier in (web, db)", Set{"tier": "db"}, true},
+		{"tier in (web,db)", Set{"tier": "cache"}, false},
+		{"tier in (web,db)", Set{}, false},
+		{"tier notin (web,db)", Set{"tier": "cache"}, true},
+		{"tier notin (web,db)", Set{}, true},
+		{"tier notin (web,db)", Set{"tier": "web"}, false},
+		{"tier", Set{"tier": ""}, true},
+		{"tier", Set{"app": "x"}, false},
+		{"!tier", Set{"app": "x"}, true},
+		{"!tier", Set{"tier": "web"}, false},
+		{"example.com/tier=web", Set{"example.com/tier": "web"}, true},
+		{"tier=web, app in (x,y), !canary", Set{"tier": "web", "app": "y"}, true},
+		{"tier=web, app in (x,y), !canary", Set{"tier": "web", "app": "y", "canary": "true"}, false},
+	} {
+		s, err := Parse(test.selector)
+		if err != nil {
+			t.Errorf("%s: %v", test.selector, err)
+			continue
+		}
+		if got := s.Matches(test.labels); got != test.matches {
+			t.Errorf("%s: got %v matching %v, expected %v", test.selector, got, test.labels, test.matches)
+		}
+	}
+}
+
+func TestParseRequirements(t *testing.T) {
+	s, err := Parse("a=1,b==2,c!=3,d in (5,4),e notin (6),f,!g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "a=1,b==2,c!=3,d in (4,5),e notin (6),f,!g"
+	if s.String() != expected {
+		t.Errorf("got '%s', expected '%s'", s, expected)
+	}
+	if ops := []Operator{Equals, DoubleEquals, NotEquals, In, NotIn, Exists, DoesNotExist}; len(s) != len(ops) {
+		t.Fatalf("got %d requirements, expected %d", len(s), len(ops))
+	} else {
+		for i, op := range ops {
+			if s[i].Operator != op {
+				t.Errorf("%s: got operator '%s', expected '%s'", s[i].Key, s[i].Operator, op)
+			}
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, selector := range []string{
+		"tier=web,",
+		",tier",
+		"tier in (web",
+		"tier in web)",
+		"tier in ((web))",
+		"tier in ()",
+		"tier within (web)",
+		"tier in (web) x",
+		"=web",
+		"-tier=web",
+		"tier=we b",
+		"Example.com/tier=web",
+		"tier=" + strings.Repeat("a", 64),
+	} {
+		if _, err := Parse(selector); err == nil {
+			t.Errorf("%q: expected an error", selector)
+		}
+	}
+}
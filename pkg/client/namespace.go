@@ -0,0 +1,46 @@
+package client
+
+import (
+	"fmt"
+	"path"
+)
+
+// NamespaceFilter selects namespaces by glob patterns as understood by
+// path.Match. An empty include list selects every namespace and exclusions
+// take precedence over inclusions.
+type NamespaceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Validate checks every pattern is well formed.
+func (f *NamespaceFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern '%s': %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether namespace is selected. Cluster scoped objects,
+// which have no namespace, and nil filters always match.
+func (f *NamespaceFilter) Matches(namespace string) bool {
+	if f == nil || namespace == "" {
+		return true
+	}
+	for _, pattern := range f.Exclude {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,509 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	log "github.com/glerchundi/logrus"
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+// BackpressurePolicy decides what happens to new events when the consumer
+// falls behind and the queue buffer is full.
+type BackpressurePolicy string
+
+const (
+	// Wait for the consumer, stalling the informers.
+	Block BackpressurePolicy = "block"
+	// Discard the event being queued.
+	DropNewest BackpressurePolicy = "drop-newest"
+	// Discard the oldest queued event to make room.
+	DropOldest BackpressurePolicy = "drop-oldest"
+	// Overflow to a bounded on-disk queue, blocking once it is full.
+	Spill BackpressurePolicy = "spill"
+)
+
+func ParseBackpressurePolicy(s string) (BackpressurePolicy, error) {
+	switch p := BackpressurePolicy(s); p {
+	case Block, DropNewest, DropOldest, Spill:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown backpressure policy '%s', valid ones are: %s, %s, %s, %s", s, Block, DropNewest, DropOldest, Spill)
+}
+
+type QueueConfig struct {
+	// Events buffered in memory.
+	Size int
+	Policy BackpressurePolicy
+	// Directory and maximum size in bytes of the spill file.
+	SpillDir string
+	SpillLimit int64
+}
+
+// QueueStats counts the events affected by the backpressure policy.
+type QueueStats struct {
+	DroppedNewest uint64
+	DroppedOldest uint64
+	Spilled uint64
+}
+
+// Queue buffers the events flowing from the informers to the consumer.
+type Queue struct {
+	config *QueueConfig
+	ch chan *Event
+
+	mu sync.Mutex
+	stats QueueStats
+	spill *spillQueue
+
+	// spill pump signaling
+	spilled chan struct{}
+	space chan struct{}
+	closing chan struct{}
+	pumpDone chan struct{}
+}
+
+func NewQueue(config *QueueConfig) (*Queue, error) {
+	if config.Size < 1 {
+		return nil, fmt.Errorf("queue size must be positive, got %d", config.Size)
+	}
+
+	q := &Queue{
+		config: config,
+		ch: make(chan *Event, config.Size),
+		closing: make(chan struct{}),
+	}
+
+	if config.Policy == Spill {
+		spill, err := openSpillQueue(filepath.Join(config.SpillDir, "spill.jsonl"), config.SpillLimit)
+		if err != nil {
+			return nil, err
+		}
+		q.spill = spill
+		q.spilled = make(chan struct{}, 1)
+		q.space = make(chan struct{}, 1)
+		q.pumpDone = make(chan struct{})
+		go q.pump()
+	}
+
+	return q, nil
+}
+
+// C returns the channel the consumer receives events from.
+func (q *Queue) C() <-chan *Event {
+	return q.ch
+}
+
+// Len returns the number of queued events, including spilled ones.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.ch)
+	if q.spill != nil {
+		n += q.spill.count
+	}
+	return n
+}
+
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
+
+// Put queues e applying the backpressure policy when the buffer is full,
+// it only fails if ctx is done while blocked or the spill file fails.
+func (q *Queue) Put(ctx context.Context, e *Event) error {
+	switch q.config.Policy {
+	case DropNewest:
+		select {
+		case q.ch <- e:
+		default:
+			q.mu.Lock()
+			q.stats.DroppedNewest++
+			dropped := q.stats.DroppedNewest
+			q.mu.Unlock()
+			log.Warnf("queue full, discarding newest event (%s %s), %d dropped so far", e.Type, e.Resource, dropped)
+		}
+		return nil
+	case DropOldest:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for {
+			select {
+			case q.ch <- e:
+				return nil
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.stats.DroppedOldest++
+				log.Warnf("queue full, discarding oldest event (%s %s), %d dropped so far", old.Type, old.Resource, q.stats.DroppedOldest)
+			default:
+			}
+		}
+	case Spill:
+		return q.putSpill(ctx, e)
+	}
+
+	select {
+	case q.ch <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) putSpill(ctx context.Context, e *Event) error {
+	var data []byte
+	for {
+		q.mu.Lock()
+		// keep ordering, nothing skips the spilled events
+		if q.spill.count == 0 {
+			select {
+			case q.ch <- e:
+				q.mu.Unlock()
+				return nil
+			default:
+			}
+		}
+
+		if data == nil {
+			var err error
+			if data, err = encodeEvent(e); err != nil {
+				q.mu.Unlock()
+				return err
+			}
+		}
+
+		err := q.spill.push(data)
+		if err == nil {
+			q.stats.Spilled++
+		}
+		empty := q.spill.count == 0
+		q.mu.Unlock()
+
+		switch err {
+		case nil:
+			signal(q.spilled)
+			return nil
+		case errSpillFull:
+			if empty {
+				// larger than the limit, nothing would ever make room
+				// for it so wait for the buffer
+				select {
+				case q.ch <- e:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			select {
+			case <-q.space:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// pumpRetryInterval between attempts to move a spilled event back to a full
+// buffer.
+const pumpRetryInterval = 10 * time.Millisecond
+
+// pump moves spilled events back to memory as the consumer frees room.
+func (q *Queue) pump() {
+	defer close(q.pumpDone)
+	for {
+		q.mu.Lock()
+		data, err := q.spill.peek()
+		q.mu.Unlock()
+		if err != nil {
+			log.Errorf("unable to read spilled event, discarding it: %v", err)
+			q.popSpilled()
+			continue
+		}
+
+		if data == nil {
+			select {
+			case <-q.spilled:
+				continue
+			case <-q.closing:
+				return
+			}
+		}
+
+		e, err := decodeEvent(data)
+		if err != nil {
+			log.Errorf("unable to decode spilled event, discarding it: %v", err)
+			q.popSpilled()
+			continue
+		}
+
+		for !q.handOver(e) {
+			select {
+			case <-time.After(pumpRetryInterval):
+			case <-q.closing:
+				return
+			}
+		}
+	}
+}
+
+// handOver moves the first spilled event, e, to the buffer if there is room.
+// It is not removed from the spill file until then, keeping new events
+// behind it, and both happen at once so Len never counts it twice.
+func (q *Queue) handOver(e *Event) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.ch <- e:
+	default:
+		return false
+	}
+	if err := q.spill.pop(); err != nil {
+		log.Errorf("unable to remove spilled event: %v", err)
+	}
+	signal(q.space)
+	return true
+}
+
+// popSpilled discards the first spilled event.
+func (q *Queue) popSpilled() {
+	q.mu.Lock()
+	err := q.spill.pop()
+	q.mu.Unlock()
+	if err != nil {
+		log.Errorf("unable to remove spilled event: %v", err)
+	}
+	signal(q.space)
+}
+
+// Close releases the spill file, queued events not yet received are lost.
+func (q *Queue) Close() error {
+	close(q.closing)
+	if q.spill == nil {
+		return nil
+	}
+	<-q.pumpDone
+	return q.spill.close()
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// queuedEvent is the serialized form of an Event.
+type queuedEvent struct {
+	Type kapi.EventType `json:"type"`
+	Resource string `json:"resource"`
+	Tombstone bool `json:"tombstone,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	ResumeVersion string `json:"resumeVersion,omitempty"`
+	Object json.RawMessage `json:"object"`
+	Previous json.RawMessage `json:"previous,omitempty"`
+}
+
+func encodeEvent(e *Event) ([]byte, error) {
+	qe := &queuedEvent{Type: e.Type, Resource: e.Resource, Tombstone: e.Tombstone, Changed: e.Changed, ResumeVersion: e.ResumeVersion}
+
+	var err error
+	if qe.Object, err = json.Marshal(e.Object); err != nil {
+		return nil, err
+	}
+	if e.Previous != nil {
+		if qe.Previous, err = json.Marshal(e.Previous); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(qe)
+}
+
+func decodeEvent(data []byte) (*Event, error) {
+	qe := &queuedEvent{}
+	if err := json.Unmarshal(data, qe); err != nil {
+		return nil, err
+	}
+
+	rc, err := lookupResource(qe.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Event{Type: qe.Type, Resource: qe.Resource, Kind: rc.kind, Tombstone: qe.Tombstone, Changed: qe.Changed, ResumeVersion: qe.ResumeVersion}
+	e.Object = rc.item()
+	if err := json.Unmarshal(qe.Object, e.Object); err != nil {
+		return nil, err
+	}
+	if len(qe.Previous) > 0 {
+		e.Previous = rc.item()
+		if err := json.Unmarshal(qe.Previous, e.Previous); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+var errSpillFull = errors.New("spill queue is full")
+
+// spillQueue is a bounded FIFO of newline delimited records stored in a
+// file, which is truncated every time it becomes empty and compacted once
+// half the limit has been popped. It is not safe for concurrent use.
+type spillQueue struct {
+	path string
+	limit int64
+	w *os.File
+	rf *os.File
+	r *bufio.Reader
+	// bytes in the file
+	size int64
+	// bytes popped, the offset of the next record
+	consumed int64
+	// records pushed and not popped
+	count int
+	// record returned by peek and not yet popped
+	head []byte
+}
+
+func openSpillQueue(path string, limit int64) (*spillQueue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	rf, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return &spillQueue{path: path, limit: limit, w: w, rf: rf, r: bufio.NewReader(rf)}, nil
+}
+
+func (s *spillQueue) push(data []byte) error {
+	if s.size+int64(len(data))+1 > s.limit {
+		return errSpillFull
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.size += int64(len(data)) + 1
+	s.count++
+	return nil
+}
+
+// peek returns the oldest record, nil if empty.
+func (s *spillQueue) peek() ([]byte, error) {
+	if s.head != nil || s.count == 0 {
+		return s.head, nil
+	}
+
+	line, err := s.r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		return nil, fmt.Errorf("truncated record")
+	}
+
+	s.head = line[:len(line)-1]
+	return s.head, nil
+}
+
+func (s *spillQueue) pop() error {
+	if s.count == 0 {
+		return nil
+	}
+	if s.head != nil {
+		s.consumed += int64(len(s.head)) + 1
+	}
+	s.head = nil
+	s.count--
+
+	// reclaim the space once drained, or popped records take half of it
+	if s.count == 0 {
+		if err := s.w.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := s.rf.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+		s.r.Reset(s.rf)
+		s.size = 0
+		s.consumed = 0
+	} else if s.consumed >= s.limit/2 {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// compact replaces the file with the records not popped yet. On failure
+// the current file is kept.
+func (s *spillQueue) compact() error {
+	tmp := s.path + ".tmp"
+	w, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	var rf *os.File
+	n, err := io.Copy(w, s.r)
+	if err == nil {
+		// open before renaming, the handles follow the file
+		rf, err = os.Open(tmp)
+	}
+	if err == nil {
+		if err = os.Rename(tmp, s.path); err != nil {
+			rf.Close()
+		}
+	}
+	if err != nil {
+		w.Close()
+		os.Remove(tmp)
+		return s.rewind(err)
+	}
+
+	s.w.Close()
+	s.rf.Close()
+	s.w, s.rf = w, rf
+	s.r.Reset(rf)
+	s.size = n
+	s.consumed = 0
+	return nil
+}
+
+// rewind moves the reader back to the next record after a failed compaction.
+func (s *spillQueue) rewind(err error) error {
+	if _, serr := s.rf.Seek(s.consumed, os.SEEK_SET); serr != nil {
+		return serr
+	}
+	s.r.Reset(s.rf)
+	return err
+}
+
+func (s *spillQueue) close() error {
+	s.rf.Close()
+	if err := s.w.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	"golang.org/x/net/context"
+)
+
+func newQueueEvent(name string) *Event {
+	return &Event{Type: kapi.Added, Resource: "services", Kind: "Service", Object: newService(name, name, "1")}
+}
+
+func newSpillQueue(t *testing.T, size int, limit int64) (*Queue, func()) {
+	dir, err := ioutil.TempDir("", "kubelistener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQueue(&QueueConfig{Size: size, Policy: Spill, SpillDir: dir, SpillLimit: limit})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return q, func() {
+		q.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// receive returns the names of the next n events.
+func receive(t *testing.T, q *Queue, n int) []string {
+	names := make([]string, 0, n)
+	for len(names) < n {
+		select {
+		case e := <-q.C():
+			names = append(names, e.Object.(*kapi.Service).Name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %v, expected %d events", names, n)
+		}
+	}
+	return names
+}
+
+func TestQueuePolicies(t *testing.T) {
+	for _, test := range []struct {
+		policy   BackpressurePolicy
+		expected []string
+		stats    QueueStats
+	}{
+		{Block, []string{"a", "b"}, QueueStats{}},
+		{DropNewest, []string{"a", "b"}, QueueStats{DroppedNewest: 2}},
+		{DropOldest, []string{"c", "d"}, QueueStats{DroppedOldest: 2}},
+	} {
+		q, err := NewQueue(&QueueConfig{Size: 2, Policy: test.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"a", "b", "c", "d"} {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			err := q.Put(ctx, newQueueEvent(name))
+			cancel()
+			// blocking puts only give up when ctx is done
+			if test.policy == Block && name > "b" {
+				if err != context.DeadlineExceeded {
+					t.Errorf("%s: got %v putting %s, expected the deadline to be exceeded", test.policy, err, name)
+				}
+			} else if err != nil {
+				t.Errorf("%s: %v", test.policy, err)
+			}
+		}
+
+		if q.Len() != len(test.expected) {
+			t.Errorf("%s: got %d queued events, expected %d", test.policy, q.Len(), len(test.expected))
+		}
+		names := receive(t, q, len(test.expected))
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s: received %v, expected %v", test.policy, names, test.expected)
+		}
+		if stats := q.Stats(); stats != test.stats {
+			t.Errorf("%s: got stats %+v, expected %+v", test.policy, stats, test.stats)
+		}
+		q.Close()
+	}
+}
+
+func TestSpillOversizedEvent(t *testing.T) {
+	q, cleanup := newSpillQueue(t, 1, 10)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Put(ctx, newQueueEvent("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// b never fits in the spill file so it waits for a
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Put(ctx, newQueueEvent("b"))
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected b to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if names := receive(t, q, 2); names[0] != "a" || names[1] != "b" {
+		t.Errorf("received %v, expected [a b]", names)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpillQueueCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubelistener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// room for four records
+	s, err := openSpillQueue(filepath.Join(dir, "spill.jsonl"), 4*4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	push := func(records ...string) {
+		for _, r := range records {
+			if err := s.push([]byte(r)); err != nil {
+				t.Fatalf("push %s: %v", r, err)
+			}
+		}
+	}
+	pop := func(expected ...string) {
+		for _, r := range expected {
+			data, err := s.peek()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != r {
+				t.Fatalf("peeked %s, expected %s", data, r)
+			}
+			if err := s.pop(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	push("aaa", "bbb", "ccc", "ddd")
+	if err := s.push([]byte("eee")); err != errSpillFull {
+		t.Fatalf("got %v, expected a full spill queue", err)
+	}
+	pop("aaa")
+	if err := s.push([]byte("eee")); err != errSpillFull {
+		t.Fatalf("got %v, expected a full spill queue until compacted", err)
+	}
+	// popping half of the limit frees it without draining
+	pop("bbb")
+	push("eee", "fff")
+	pop("ccc", "ddd", "eee")
+	push("ggg")
+	pop("fff", "ggg")
+	if s.count != 0 || s.size != 0 {
+		t.Errorf("got %d records in %d bytes, expected an empty spill queue", s.count, s.size)
+	}
+}
+
+func TestSpillOrder(t *testing.T) {
+	data, err := encodeEvent(newQueueEvent("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// room for three spilled events
+	q, cleanup := newSpillQueue(t, 2, 3*int64(len(data)+1))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var expected []string
+	for n := 0; n < 20; n++ {
+		expected = append(expected, fmt.Sprintf("e%02d", n))
+	}
+	done := make(chan error, 1)
+	go func() {
+		for _, name := range expected {
+			if err := q.Put(ctx, newQueueEvent(name)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	names := receive(t, q, len(expected))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for n := range expected {
+		if names[n] != expected[n] {
+			t.Fatalf("received %v, expected %v", names, expected)
+		}
+	}
+	if stats := q.Stats(); stats.Spilled == 0 {
+		t.Error("expected events to be spilled")
+	}
+	if q.Len() != 0 {
+		t.Errorf("got %d queued events, expected none", q.Len())
+	}
+}
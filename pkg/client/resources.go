@@ -0,0 +1,231 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+// resourceCreator describes a resource an Informer can watch and how to
+// decode it.
+type resourceCreator struct {
+	// name identifies the resource, the plural for the registered v1 ones
+	// and 'group/version/resource' for the rest.
+	name string
+	// groupVersion serving the resource, e.g. 'v1' or 'extensions/v1beta1'.
+	groupVersion string
+	// resource is the plural name used in URLs, e.g. 'services'.
+	resource string
+	kind     string
+	// aliases accepted besides the resource and the lowercase kind.
+	aliases    []string
+	namespaced bool
+	// listOnly resources can not be watched, they are polled instead.
+	listOnly bool
+	item     func() kruntime.Object
+	list     func() kruntime.Object
+}
+
+var resourceCreators = []*resourceCreator{
+	// namespaced
+	{
+		resource: "endpoints", kind: "Endpoints", aliases: []string{"ep"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.Endpoints{} },
+		list: func() kruntime.Object { return &kapi.EndpointsList{} },
+	},
+	{
+		resource: "events", kind: "Event", aliases: []string{"ev"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.Event{} },
+		list: func() kruntime.Object { return &kapi.EventList{} },
+	},
+	{
+		resource: "limitranges", kind: "LimitRange", aliases: []string{"limits"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.LimitRange{} },
+		list: func() kruntime.Object { return &kapi.LimitRangeList{} },
+	},
+	{
+		resource: "persistentvolumeclaims", kind: "PersistentVolumeClaim", aliases: []string{"pvc"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.PersistentVolumeClaim{} },
+		list: func() kruntime.Object { return &kapi.PersistentVolumeClaimList{} },
+	},
+	{
+		resource: "pods", kind: "Pod", aliases: []string{"po"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.Pod{} },
+		list: func() kruntime.Object { return &kapi.PodList{} },
+	},
+	{
+		resource: "podtemplates", kind: "PodTemplate", namespaced: true,
+		item: func() kruntime.Object { return &kapi.PodTemplate{} },
+		list: func() kruntime.Object { return &kapi.PodTemplateList{} },
+	},
+	{
+		resource: "replicationcontrollers", kind: "ReplicationController", aliases: []string{"rc"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.ReplicationController{} },
+		list: func() kruntime.Object { return &kapi.ReplicationControllerList{} },
+	},
+	{
+		resource: "resourcequotas", kind: "ResourceQuota", aliases: []string{"quota"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.ResourceQuota{} },
+		list: func() kruntime.Object { return &kapi.ResourceQuotaList{} },
+	},
+	{
+		resource: "secrets", kind: "Secret", namespaced: true,
+		item: func() kruntime.Object { return &kapi.Secret{} },
+		list: func() kruntime.Object { return &kapi.SecretList{} },
+	},
+	{
+		resource: "serviceaccounts", kind: "ServiceAccount", aliases: []string{"sa"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.ServiceAccount{} },
+		list: func() kruntime.Object { return &kapi.ServiceAccountList{} },
+	},
+	{
+		resource: "services", kind: "Service", aliases: []string{"svc"}, namespaced: true,
+		item: func() kruntime.Object { return &kapi.Service{} },
+		list: func() kruntime.Object { return &kapi.ServiceList{} },
+	},
+	// cluster scoped
+	{
+		// probed on every request, they have neither resource version nor
+		// watch support
+		resource: "componentstatuses", kind: "ComponentStatus", aliases: []string{"cs"}, listOnly: true,
+		item: func() kruntime.Object { return &kapi.ComponentStatus{} },
+		list: func() kruntime.Object { return &kapi.ComponentStatusList{} },
+	},
+	{
+		resource: "namespaces", kind: "Namespace", aliases: []string{"ns"},
+		item: func() kruntime.Object { return &kapi.Namespace{} },
+		list: func() kruntime.Object { return &kapi.NamespaceList{} },
+	},
+	{
+		resource: "nodes", kind: "Node", aliases: []string{"no"},
+		item: func() kruntime.Object { return &kapi.Node{} },
+		list: func() kruntime.Object { return &kapi.NodeList{} },
+	},
+	{
+		resource: "persistentvolumes", kind: "PersistentVolume", aliases: []string{"pv"},
+		item: func() kruntime.Object { return &kapi.PersistentVolume{} },
+		list: func() kruntime.Object { return &kapi.PersistentVolumeList{} },
+	},
+}
+
+var (
+	// resources by their canonical name
+	resourceCreatorMap = make(map[string]*resourceCreator)
+	// canonical names by every accepted name
+	resourceAliases = make(map[string]string)
+)
+
+func init() {
+	for _, rc := range resourceCreators {
+		rc.name = rc.resource
+		rc.groupVersion = "v1"
+		resourceCreatorMap[rc.resource] = rc
+		names := append([]string{rc.resource, strings.ToLower(rc.kind)}, rc.aliases...)
+		for _, name := range names {
+			if r, ok := resourceAliases[name]; ok && r != rc.resource {
+				panic(fmt.Sprintf("resource name '%s' registered twice", name))
+			}
+			resourceAliases[name] = rc.resource
+		}
+	}
+}
+
+// Resources returns every resource an Informer can watch, sorted.
+func Resources() []string {
+	resources := make([]string, 0, len(resourceCreatorMap))
+	for resource := range resourceCreatorMap {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// ResolveResource returns the canonical name of a resource given its plural,
+// singular or short name, e.g. 'svc' or 'service' resolve to 'services'.
+// Resources of any API group can be given as 'group/version/resource', or
+// 'version/resource' for the legacy one.
+func ResolveResource(name string) (string, error) {
+	rc, err := lookupResource(name)
+	if err != nil {
+		return "", err
+	}
+	return rc.name, nil
+}
+
+func lookupResource(name string) (*resourceCreator, error) {
+	name = strings.ToLower(name)
+	if resource, ok := resourceAliases[name]; ok {
+		return resourceCreatorMap[resource], nil
+	}
+	if strings.Contains(name, "/") {
+		return unstructuredCreator(name)
+	}
+	return nil, fmt.Errorf("'%s' is not a valid resource type, valid ones are: %s or group/version/resource", name, strings.Join(Resources(), ", "))
+}
+
+// unstructuredCreator returns the creator of a resource without Golang
+// structs, decoded into runtime.Unstructured. Its scope is unknown so it is
+// assumed to be namespaced.
+func unstructuredCreator(name string) (*resourceCreator, error) {
+	parts := strings.Split(name, "/")
+	for _, part := range parts {
+		if part == "" || len(parts) > 3 {
+			return nil, fmt.Errorf("'%s' is not a valid resource, expected group/version/resource", name)
+		}
+	}
+
+	groupVersion := strings.Join(parts[:len(parts)-1], "/")
+	resource := parts[len(parts)-1]
+	if rc, ok := resourceCreatorMap[resource]; ok && groupVersion == rc.groupVersion {
+		return rc, nil
+	}
+
+	return newUnstructuredCreator(groupVersion, resource, true), nil
+}
+
+func newUnstructuredCreator(groupVersion, resource string, namespaced bool) *resourceCreator {
+	return &resourceCreator{
+		name:         groupVersion + "/" + resource,
+		groupVersion: groupVersion,
+		resource:     resource,
+		namespaced:   namespaced,
+		item:         func() kruntime.Object { return &kruntime.Unstructured{} },
+		list:         func() kruntime.Object { return &kruntime.UnstructuredList{} },
+	}
+}
+
+// ParseResources parses a comma separated list of resources, 'all' stands
+// for every known one.
+func ParseResources(spec string) ([]string, error) {
+	return parseResources(spec, Resources(), ResolveResource)
+}
+
+func parseResources(spec string, all []string, resolve func(string) (string, error)) ([]string, error) {
+	var resources []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.ToLower(name) == "all" {
+			return all, nil
+		}
+
+		resource, err := resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[resource] {
+			seen[resource] = true
+			resources = append(resources, resource)
+		}
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no resource to watch")
+	}
+	return resources, nil
+}
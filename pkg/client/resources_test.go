@@ -0,0 +1,99 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/glerchundi/kubelistener/pkg/client/api/meta"
+)
+
+func TestResourceCreatorsDecode(t *testing.T) {
+	for _, rc := range resourceCreators {
+		item := fmt.Sprintf(`{"kind":%q,"apiVersion":"v1","metadata":{"name":"foo","namespace":"bar","resourceVersion":"7"}}`, rc.kind)
+		list := fmt.Sprintf(`{"kind":"%sList","apiVersion":"v1","metadata":{"resourceVersion":"8"},"items":[%s]}`, rc.kind, item)
+
+		obj := rc.item()
+		if err := json.Unmarshal([]byte(item), obj); err != nil {
+			t.Errorf("%s: unable to decode item: %v", rc.name, err)
+			continue
+		}
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			t.Errorf("%s: %v", rc.name, err)
+			continue
+		}
+		if m.GetName() != "foo" || m.GetResourceVersion() != "7" {
+			t.Errorf("%s: decoded item %s at %s, expected foo at 7", rc.name, m.GetName(), m.GetResourceVersion())
+		}
+		if kind := decodedKind(obj); kind != rc.kind {
+			t.Errorf("%s: decoded item kind %s, expected %s", rc.name, kind, rc.kind)
+		}
+
+		l := rc.list()
+		if err := json.Unmarshal([]byte(list), l); err != nil {
+			t.Errorf("%s: unable to decode list: %v", rc.name, err)
+			continue
+		}
+		lm, err := meta.ListAccessor(l)
+		if err != nil {
+			t.Errorf("%s: %v", rc.name, err)
+			continue
+		}
+		if lm.GetResourceVersion() != "8" {
+			t.Errorf("%s: decoded list at %s, expected 8", rc.name, lm.GetResourceVersion())
+		}
+		items, err := ExtractList(l)
+		if err != nil {
+			t.Errorf("%s: %v", rc.name, err)
+			continue
+		}
+		if len(items) != 1 {
+			t.Errorf("%s: decoded %d items, expected 1", rc.name, len(items))
+			continue
+		}
+		if key, _ := KeyFunc(items[0]); key != "bar/foo" {
+			t.Errorf("%s: decoded list item %s, expected bar/foo", rc.name, key)
+		}
+	}
+}
+
+func TestLookupResource(t *testing.T) {
+	for _, rc := range resourceCreators {
+		names := append([]string{rc.resource, rc.kind, strings.ToLower(rc.kind), "v1/" + rc.resource}, rc.aliases...)
+		for _, name := range names {
+			got, err := lookupResource(name)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if got != rc {
+				t.Errorf("%s: resolved to %s, expected %s", name, got.name, rc.name)
+			}
+		}
+	}
+
+	rc, err := lookupResource("extensions/v1beta1/deployments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.name != "extensions/v1beta1/deployments" || rc.groupVersion != "extensions/v1beta1" || rc.resource != "deployments" {
+		t.Errorf("unexpected unstructured resource %+v", rc)
+	}
+
+	for _, name := range []string{"unknown", "a/b/c/d", "v1/"} {
+		if _, err := lookupResource(name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// decodedKind returns the kind of a decoded object.
+func decodedKind(obj interface{}) string {
+	t, err := meta.TypeAccessor(obj)
+	if err != nil {
+		return ""
+	}
+	return t.GetKind()
+}
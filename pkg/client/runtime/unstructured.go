@@ -0,0 +1,126 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+)
+
+// UnstructuredList is a list of objects without Golang structs, as returned
+// by the list endpoints of any API group.
+type UnstructuredList struct {
+	TypeMeta        `json:",inline"`
+	SelfLink        string
+	ResourceVersion string
+	Items           []Unstructured
+}
+
+func (*UnstructuredList) IsAnAPIObject() {}
+
+// UnmarshalJSON decodes the whole object into the Object map keeping the
+// type metadata in sync.
+func (u *Unstructured) UnmarshalJSON(data []byte) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	// keep integers as they are instead of turning them into floats
+	d.UseNumber()
+
+	obj := make(map[string]interface{})
+	if err := d.Decode(&obj); err != nil {
+		return err
+	}
+	if obj == nil {
+		obj = make(map[string]interface{})
+	}
+	u.Object = obj
+	u.APIVersion = u.getString("apiVersion")
+	u.Kind = u.getString("kind")
+	return nil
+}
+
+func (u *Unstructured) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.Object)
+}
+
+// UnmarshalJSON decodes the list, items lacking type metadata inherit it
+// from the list kind, e.g. 'Deployment' for a 'DeploymentList'.
+func (l *UnstructuredList) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		TypeMeta `json:",inline"`
+		Metadata struct {
+			SelfLink        string `json:"selfLink"`
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []Unstructured `json:"items"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	l.TypeMeta = raw.TypeMeta
+	l.SelfLink = raw.Metadata.SelfLink
+	l.ResourceVersion = raw.Metadata.ResourceVersion
+	l.Items = raw.Items
+
+	kind := strings.TrimSuffix(l.Kind, "List")
+	for i := range l.Items {
+		item := &l.Items[i]
+		if item.Kind == "" && kind != "" {
+			item.Kind = kind
+			item.Object["kind"] = kind
+		}
+		if item.APIVersion == "" && l.APIVersion != "" {
+			item.APIVersion = l.APIVersion
+			item.Object["apiVersion"] = l.APIVersion
+		}
+	}
+	return nil
+}
+
+// Accessors for meta.Object and meta.Type
+
+func (u *Unstructured) GetAPIVersion() string        { return u.APIVersion }
+func (u *Unstructured) GetKind() string              { return u.Kind }
+func (u *Unstructured) GetNamespace() string         { return u.getMetadataString("namespace") }
+func (u *Unstructured) GetName() string              { return u.getMetadataString("name") }
+func (u *Unstructured) GetUID() unversioned.UID      { return unversioned.UID(u.getMetadataString("uid")) }
+func (u *Unstructured) GetResourceVersion() string   { return u.getMetadataString("resourceVersion") }
+func (u *Unstructured) GetLabels() map[string]string { return u.getMetadataStringMap("labels") }
+func (u *Unstructured) GetAnnotations() map[string]string {
+	return u.getMetadataStringMap("annotations")
+}
+
+// Accessors for meta.List
+
+func (l *UnstructuredList) GetSelfLink() string        { return l.SelfLink }
+func (l *UnstructuredList) GetResourceVersion() string { return l.ResourceVersion }
+
+func (u *Unstructured) getString(key string) string {
+	s, _ := u.Object[key].(string)
+	return s
+}
+
+func (u *Unstructured) getMetadata() map[string]interface{} {
+	m, _ := u.Object["metadata"].(map[string]interface{})
+	return m
+}
+
+func (u *Unstructured) getMetadataString(key string) string {
+	s, _ := u.getMetadata()[key].(string)
+	return s
+}
+
+func (u *Unstructured) getMetadataStringMap(key string) map[string]string {
+	m, ok := u.getMetadata()[key].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			out[k] = s
+		}
+	}
+	return out
+}
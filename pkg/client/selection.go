@@ -0,0 +1,52 @@
+package client
+
+import (
+	"github.com/glerchundi/kubelistener/pkg/client/api/meta"
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	"github.com/glerchundi/kubelistener/pkg/client/labels"
+	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+const (
+	// Entered is synthesized when a modified object starts matching the
+	// client side selector.
+	Entered kapi.EventType = "ENTERED"
+	// Left is synthesized when a modified object stops matching the client
+	// side selector.
+	Left kapi.EventType = "LEFT"
+)
+
+// selection applies a client side label selector to e, comparing the
+// previous and new state of modified objects. It returns nil for events
+// about objects outside the selection.
+func selection(s labels.Selector, e *Event) *Event {
+	if s == nil {
+		return e
+	}
+
+	matches := selects(s, e.Object)
+	if e.Type != kapi.Modified || e.Previous == nil {
+		if !matches {
+			return nil
+		}
+		return e
+	}
+
+	switch matched := selects(s, e.Previous); {
+	case matched && !matches:
+		e.Type = Left
+	case !matched && matches:
+		e.Type = Entered
+	case !matched && !matches:
+		return nil
+	}
+	return e
+}
+
+func selects(s labels.Selector, obj kruntime.Object) bool {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(m.GetLabels()))
+}
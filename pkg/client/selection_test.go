@@ -0,0 +1,54 @@
+package client
+
+import (
+	"testing"
+
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	"github.com/glerchundi/kubelistener/pkg/client/labels"
+)
+
+func newLabeledService(rv string, ls map[string]string) *kapi.Service {
+	s := newService("foo", "1", rv)
+	s.Labels = ls
+	return s
+}
+
+func TestSelection(t *testing.T) {
+	selector, err := labels.Parse("tier=web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := map[string]string{"tier": "web"}
+	db := map[string]string{"tier": "db"}
+
+	for _, test := range []struct {
+		name     string
+		event    *Event
+		expected kapi.EventType
+	}{
+		{"added selected", &Event{Type: kapi.Added, Object: newLabeledService("1", web)}, kapi.Added},
+		{"added not selected", &Event{Type: kapi.Added, Object: newLabeledService("1", db)}, ""},
+		{"modified selected", &Event{Type: kapi.Modified, Object: newLabeledService("2", web), Previous: newLabeledService("1", web)}, kapi.Modified},
+		{"modified entering", &Event{Type: kapi.Modified, Object: newLabeledService("2", web), Previous: newLabeledService("1", db)}, Entered},
+		{"modified leaving", &Event{Type: kapi.Modified, Object: newLabeledService("2", db), Previous: newLabeledService("1", web)}, Left},
+		{"modified not selected", &Event{Type: kapi.Modified, Object: newLabeledService("2", db), Previous: newLabeledService("1", nil)}, ""},
+		{"modified without previous", &Event{Type: kapi.Modified, Object: newLabeledService("2", web)}, kapi.Modified},
+		{"modified without previous not selected", &Event{Type: kapi.Modified, Object: newLabeledService("2", db)}, ""},
+		{"deleted selected", &Event{Type: kapi.Deleted, Object: newLabeledService("3", web)}, kapi.Deleted},
+		{"deleted after leaving", &Event{Type: kapi.Deleted, Object: newLabeledService("3", db)}, ""},
+	} {
+		e := selection(selector, test.event)
+		switch {
+		case e == nil && test.expected != "":
+			t.Errorf("%s: dropped, expected %s", test.name, test.expected)
+		case e != nil && test.expected == "":
+			t.Errorf("%s: got %s, expected it to be dropped", test.name, e.Type)
+		case e != nil && e.Type != test.expected:
+			t.Errorf("%s: got %s, expected %s", test.name, e.Type, test.expected)
+		}
+	}
+
+	if e := selection(nil, &Event{Type: kapi.Added, Object: newLabeledService("1", db)}); e == nil {
+		t.Error("expected no selector to select everything")
+	}
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/glerchundi/kubelistener/pkg/client/api/meta"
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+// Event is a change notification produced by an Informer once reconciled
+// against its local store.
+type Event struct {
+	// Type of change, one of ADDED, MODIFIED or DELETED, or ENTERED and
+	// LEFT when a client side selector is used, or BOOKMARK.
+	Type kapi.EventType
+	// Object is the new state of the object or, for DELETED events, the
+	// last known one.
+	Object kruntime.Object
+	// Previous is the state replaced by a MODIFIED event.
+	Previous kruntime.Object
+	// Changed lists the watched fields modified by a MODIFIED event.
+	Changed []string
+	// Tombstone is set on DELETED events synthesized during a resync, the
+	// deletion itself was missed and Object holds the last known state.
+	Tombstone bool
+	// Resource the object belongs to, e.g. 'services'.
+	Resource string
+	// Kind of the object, e.g. 'Service'.
+	Kind string
+	// ResumeVersion is the resource version the watch can be resumed from
+	// once this event, and the ones before it, have been handled.
+	ResumeVersion string
+}
+
+// Bookmark events carry nothing but a ResumeVersion, they are queued when
+// every event of a change was filtered out.
+const Bookmark kapi.EventType = "BOOKMARK"
+
+// Store is a thread-safe cache of objects keyed by namespace/name.
+type Store struct {
+	mu    sync.RWMutex
+	items map[string]kruntime.Object
+	// set once populated from a list
+	synced bool
+	// keys populated without notifying them, their state downstream is
+	// unknown until the next event
+	primed map[string]bool
+}
+
+func NewStore() *Store {
+	return &Store{items: make(map[string]kruntime.Object)}
+}
+
+// KeyFunc returns the 'namespace/name' key of an object, just 'name' for
+// cluster scoped ones.
+func KeyFunc(obj kruntime.Object) (string, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	if m.GetNamespace() == "" {
+		return m.GetName(), nil
+	}
+	return m.GetNamespace() + "/" + m.GetName(), nil
+}
+
+// Get returns the object stored under key.
+func (s *Store) Get(key string) (kruntime.Object, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.items[key]
+	return obj, ok
+}
+
+// List returns every stored object ordered by key.
+func (s *Store) List() []kruntime.Object {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	objs := make([]kruntime.Object, len(keys))
+	for i, key := range keys {
+		objs[i] = s.items[key]
+	}
+	return objs
+}
+
+// Apply updates the store with a watch event returning the changes it
+// represents, none if it was already known.
+func (s *Store) Apply(we *kapi.WatchEvent) ([]*Event, error) {
+	key, err := KeyFunc(we.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.items[key]
+	primed := s.primed[key]
+	delete(s.primed, key)
+	switch we.Type {
+	case kapi.Added, kapi.Modified:
+		s.items[key] = we.Object
+		if primed || (!exists && !s.synced) {
+			// watching without a previous list or primed, the object may
+			// already be known downstream so keep the reported type
+			return []*Event{{Type: we.Type, Object: we.Object}}, nil
+		}
+		return diff(old, we.Object, exists), nil
+	case kapi.Deleted:
+		delete(s.items, key)
+		if exists && !primed && !sameUID(old, we.Object) {
+			// the deleted one is a newer incarnation, report both
+			return []*Event{
+				{Type: kapi.Deleted, Object: old, Tombstone: true},
+				{Type: kapi.Deleted, Object: we.Object},
+			}, nil
+		}
+		return []*Event{{Type: kapi.Deleted, Object: we.Object}}, nil
+	}
+
+	return nil, fmt.Errorf("unable to apply %s event", we.Type)
+}
+
+// Replace resets the store to the items of list, returning the changes
+// needed to get there. Objects not present anymore are reported as
+// tombstones.
+func (s *Store) Replace(list kruntime.Object) ([]*Event, error) {
+	objs, err := ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]kruntime.Object, len(objs))
+	keys := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		key, err := KeyFunc(obj)
+		if err != nil {
+			return nil, err
+		}
+		items[key] = obj
+		keys = append(keys, key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []*Event
+	for _, key := range keys {
+		old, exists := s.items[key]
+		events = append(events, diff(old, items[key], exists)...)
+	}
+
+	var missing []string
+	for key := range s.items {
+		if _, ok := items[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	for _, key := range missing {
+		events = append(events, &Event{Type: kapi.Deleted, Object: s.items[key], Tombstone: true})
+	}
+
+	s.items = items
+	s.synced = true
+	s.primed = nil
+	return events, nil
+}
+
+// Prime resets the store to the items of list without reporting changes,
+// the first event of each of them keeps its reported type.
+func (s *Store) Prime(list kruntime.Object) error {
+	objs, err := ExtractList(list)
+	if err != nil {
+		return err
+	}
+
+	items := make(map[string]kruntime.Object, len(objs))
+	primed := make(map[string]bool, len(objs))
+	for _, obj := range objs {
+		key, err := KeyFunc(obj)
+		if err != nil {
+			return err
+		}
+		items[key] = obj
+		primed[key] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = items
+	s.synced = true
+	s.primed = primed
+	return nil
+}
+
+// diff returns the events transforming old into obj.
+func diff(old, obj kruntime.Object, exists bool) []*Event {
+	switch {
+	case !exists:
+		return []*Event{{Type: kapi.Added, Object: obj}}
+	case !sameUID(old, obj):
+		// deleted and recreated while we were not watching
+		return []*Event{
+			{Type: kapi.Deleted, Object: old, Tombstone: true},
+			{Type: kapi.Added, Object: obj},
+		}
+	case resourceVersion(old) != resourceVersion(obj):
+		return []*Event{{Type: kapi.Modified, Object: obj, Previous: old}}
+	case resourceVersion(obj) == "" && !reflect.DeepEqual(old, obj):
+		// not versioned, e.g. component statuses
+		return []*Event{{Type: kapi.Modified, Object: obj, Previous: old}}
+	}
+	return nil
+}
+
+func sameUID(a, b kruntime.Object) bool {
+	ma, erra := meta.Accessor(a)
+	mb, errb := meta.Accessor(b)
+	if erra != nil || errb != nil {
+		return true
+	}
+	// objects without uid can not be told apart
+	if ma.GetUID() == "" || mb.GetUID() == "" {
+		return true
+	}
+	return ma.GetUID() == mb.GetUID()
+}
+
+func resourceVersion(obj kruntime.Object) string {
+	if m, err := meta.Accessor(obj); err == nil {
+		return m.GetResourceVersion()
+	}
+	return ""
+}
+
+// ExtractList returns pointers to the elements of the Items field of list.
+func ExtractList(list kruntime.Object) ([]kruntime.Object, error) {
+	v := reflect.Indirect(reflect.ValueOf(list))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T is not a list", list)
+	}
+
+	items := v.FieldByName("Items")
+	if !items.IsValid() || items.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%T does not have an Items slice", list)
+	}
+
+	objs := make([]kruntime.Object, items.Len())
+	for i := range objs {
+		obj, ok := items.Index(i).Addr().Interface().(kruntime.Object)
+		if !ok {
+			return nil, fmt.Errorf("%T items are not api objects", list)
+		}
+		objs[i] = obj
+	}
+	return objs, nil
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+func newService(name, uid, rv string) *kapi.Service {
+	return &kapi.Service{ObjectMeta: kapi.ObjectMeta{Namespace: "default", Name: name, UID: unversioned.UID(uid), ResourceVersion: rv}}
+}
+
+func TestStorePrime(t *testing.T) {
+	s := NewStore()
+	list := &kapi.ServiceList{Items: []kapi.Service{*newService("a", "1", "5"), *newService("b", "2", "5")}}
+	if err := s.Prime(list); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("default/a"); !ok {
+		t.Fatal("expected primed object to be stored")
+	}
+
+	for _, test := range []struct {
+		we       *kapi.WatchEvent
+		expected []kapi.EventType
+		previous bool
+	}{
+		// primed objects keep the reported type and have no previous state
+		{&kapi.WatchEvent{Type: kapi.Modified, Object: newService("a", "1", "6")}, []kapi.EventType{kapi.Modified}, false},
+		// a replayed deletion of an older incarnation is reported alone
+		{&kapi.WatchEvent{Type: kapi.Deleted, Object: newService("b", "0", "4")}, []kapi.EventType{kapi.Deleted}, false},
+		// once seen they are compared as usual
+		{&kapi.WatchEvent{Type: kapi.Modified, Object: newService("a", "1", "6")}, nil, false},
+		{&kapi.WatchEvent{Type: kapi.Modified, Object: newService("a", "1", "7")}, []kapi.EventType{kapi.Modified}, true},
+		// unknown ones are added
+		{&kapi.WatchEvent{Type: kapi.Modified, Object: newService("c", "3", "8")}, []kapi.EventType{kapi.Added}, false},
+	} {
+		events, err := s.Apply(test.we)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(test.expected) {
+			t.Fatalf("%s %s: got %d events, expected %d", test.we.Type, resourceVersion(test.we.Object), len(events), len(test.expected))
+		}
+		for n, e := range events {
+			if e.Type != test.expected[n] {
+				t.Errorf("%s %s: got %s event, expected %s", test.we.Type, resourceVersion(test.we.Object), e.Type, test.expected[n])
+			}
+			if (e.Previous != nil) != test.previous {
+				t.Errorf("%s %s: got previous state %v", test.we.Type, resourceVersion(test.we.Object), e.Previous)
+			}
+		}
+	}
+
+	// a relist compares against every stored object
+	events, err := s.Replace(&kapi.ServiceList{Items: []kapi.Service{*newService("a", "1", "7")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != kapi.Deleted || !events[0].Tombstone {
+		t.Errorf("got %d events after relisting, expected the tombstone of c", len(events))
+	}
+}
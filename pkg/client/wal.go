@@ -0,0 +1,264 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/glerchundi/logrus"
+)
+
+const (
+	walLogFile   = "wal.log"
+	walStateFile = "state.json"
+)
+
+// WAL is a write-ahead log of the events handed to the outputs. Records are
+// acknowledged in order once delivered and the version every resource can
+// resume watching from is checkpointed along with the last acknowledged
+// record. Records left unacknowledged by a previous process are available
+// through Unacked. It is not safe for concurrent use.
+type WAL struct {
+	dir string
+	f *os.File
+	size int64
+	// last appended sequence number
+	seq uint64
+	state walState
+	// appended but not acknowledged yet, in order
+	pending []walPending
+	unacked []*Record
+}
+
+// Record is an event stored in the log.
+type Record struct {
+	Seq uint64
+	Event *Event
+}
+
+type walState struct {
+	Acked uint64 `json:"acked"`
+	ResourceVersions map[string]string `json:"resourceVersions"`
+}
+
+type walRecord struct {
+	Seq uint64 `json:"seq"`
+	Event json.RawMessage `json:"event"`
+}
+
+type walPending struct {
+	seq uint64
+	resource string
+	resumeVersion string
+}
+
+// OpenWAL opens, creating it if needed, the log stored in dir.
+func OpenWAL(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create write-ahead log directory: %v", err)
+	}
+
+	w := &WAL{dir: dir, state: walState{ResourceVersions: make(map[string]string)}}
+	if err := w.loadState(); err != nil {
+		return nil, fmt.Errorf("unable to load write-ahead log state: %v", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, walLogFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open write-ahead log: %v", err)
+	}
+	w.f = f
+	if err := w.recover(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to recover write-ahead log: %v", err)
+	}
+
+	return w, nil
+}
+
+func (w *WAL) loadState() error {
+	data, err := ioutil.ReadFile(filepath.Join(w.dir, walStateFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &w.state); err != nil {
+		return err
+	}
+	if w.state.ResourceVersions == nil {
+		w.state.ResourceVersions = make(map[string]string)
+	}
+	return nil
+}
+
+// recover reads the records not acknowledged yet, discarding a trailing one
+// partially written before a crash.
+func (w *WAL) recover() error {
+	w.seq = w.state.Acked
+
+	r := bufio.NewReader(w.f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Warnf("discarding incomplete record at the end of the write-ahead log")
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		w.size += int64(len(line))
+
+		rec := &walRecord{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			log.Warnf("discarding corrupt write-ahead log record: %v", err)
+			continue
+		}
+		if rec.Seq <= w.state.Acked {
+			continue
+		}
+		e, err := decodeEvent(rec.Event)
+		if err != nil {
+			log.Warnf("discarding write-ahead log record %d: %v", rec.Seq, err)
+			continue
+		}
+
+		w.seq = rec.Seq
+		w.pending = append(w.pending, walPending{seq: rec.Seq, resource: e.Resource, resumeVersion: e.ResumeVersion})
+		w.unacked = append(w.unacked, &Record{Seq: rec.Seq, Event: e})
+	}
+
+	if err := w.f.Truncate(w.size); err != nil {
+		return err
+	}
+	_, err := w.f.Seek(w.size, os.SEEK_SET)
+	return err
+}
+
+// Unacked returns the records a previous process did not acknowledge, they
+// must be delivered before anything else.
+func (w *WAL) Unacked() []*Record {
+	return w.unacked
+}
+
+// ResourceVersion returns the version resource can resume watching from,
+// empty if unknown.
+func (w *WAL) ResourceVersion(resource string) string {
+	return w.state.ResourceVersions[resource]
+}
+
+// Append durably stores e returning its sequence number.
+func (w *WAL) Append(e *Event) (uint64, error) {
+	data, err := encodeEvent(e)
+	if err != nil {
+		return 0, err
+	}
+	line, err := json.Marshal(&walRecord{Seq: w.seq + 1, Event: data})
+	if err != nil {
+		return 0, err
+	}
+	line = append(line, '\n')
+
+	if _, err := w.f.Write(line); err != nil {
+		w.rewind()
+		return 0, err
+	}
+	if err := w.f.Sync(); err != nil {
+		w.rewind()
+		return 0, err
+	}
+
+	w.size += int64(len(line))
+	w.seq++
+	w.pending = append(w.pending, walPending{seq: w.seq, resource: e.Resource, resumeVersion: e.ResumeVersion})
+	return w.seq, nil
+}
+
+// Checkpoint makes resource resume from resumeVersion once every record
+// appended so far is acknowledged, without storing anything.
+func (w *WAL) Checkpoint(resource, resumeVersion string) {
+	w.pending = append(w.pending, walPending{seq: w.seq, resource: resource, resumeVersion: resumeVersion})
+}
+
+// rewind drops a partially appended record so the next one starts on a
+// clean line.
+func (w *WAL) rewind() {
+	if err := w.f.Truncate(w.size); err != nil {
+		log.Warnf("unable to discard partial write-ahead log record: %v", err)
+	}
+	w.f.Seek(w.size, os.SEEK_SET)
+}
+
+// Ack acknowledges every record up to seq and checkpoints the versions
+// they can be resumed from. The log is emptied once nothing is pending.
+func (w *WAL) Ack(seq uint64) error {
+	n := 0
+	for ; n < len(w.pending) && w.pending[n].seq <= seq; n++ {
+		if p := w.pending[n]; p.resumeVersion != "" {
+			w.state.ResourceVersions[p.resource] = p.resumeVersion
+		}
+	}
+	if n == 0 && seq <= w.state.Acked {
+		return nil
+	}
+	w.pending = w.pending[n:]
+	if seq > w.state.Acked {
+		w.state.Acked = seq
+	}
+
+	if err := w.saveState(); err != nil {
+		return fmt.Errorf("unable to checkpoint write-ahead log: %v", err)
+	}
+
+	// records are skipped by sequence number, so a crash before truncating
+	// is harmless
+	if len(w.pending) == 0 && w.size > 0 {
+		if err := w.f.Truncate(0); err != nil {
+			return fmt.Errorf("unable to truncate write-ahead log: %v", err)
+		}
+		if _, err := w.f.Seek(0, os.SEEK_SET); err != nil {
+			return fmt.Errorf("unable to truncate write-ahead log: %v", err)
+		}
+		w.size = 0
+	}
+
+	return nil
+}
+
+// saveState atomically replaces the state file.
+func (w *WAL) saveState() error {
+	data, err := json.Marshal(&w.state)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(w.dir, walStateFile)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Close closes the log, unacknowledged records are kept for the next open.
+func (w *WAL) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+func newWALDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "kubelistener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func openWAL(t *testing.T, dir string) *WAL {
+	w, err := OpenWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func appendEvent(t *testing.T, w *WAL, resource, name, resumeVersion string) uint64 {
+	e := newQueueEvent(name)
+	e.Resource, e.ResumeVersion = resource, resumeVersion
+	seq, err := w.Append(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seq
+}
+
+func ack(t *testing.T, w *WAL, seq uint64) {
+	if err := w.Ack(seq); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALRecovery(t *testing.T) {
+	dir, cleanup := newWALDir(t)
+	defer cleanup()
+
+	w := openWAL(t, dir)
+	for n, name := range []string{"a", "b", "c"} {
+		resumeVersion := ""
+		if name == "c" {
+			resumeVersion = "5"
+		}
+		if seq := appendEvent(t, w, "services", name, resumeVersion); seq != uint64(n+1) {
+			t.Fatalf("got sequence number %d, expected %d", seq, n+1)
+		}
+	}
+	ack(t, w, 1)
+	w.Close()
+
+	// a record partially written before a crash is discarded
+	f, err := os.OpenFile(filepath.Join(dir, walLogFile), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":4,"ev`)
+	f.Close()
+
+	w = openWAL(t, dir)
+	unacked := w.Unacked()
+	if len(unacked) != 2 {
+		t.Fatalf("got %d unacknowledged records, expected 2", len(unacked))
+	}
+	for n, name := range []string{"b", "c"} {
+		if rec := unacked[n]; rec.Seq != uint64(n+2) || rec.Event.Object.(*kapi.Service).Name != name {
+			t.Errorf("got record %d of %s, expected %d of %s", rec.Seq, rec.Event.Object.(*kapi.Service).Name, n+2, name)
+		}
+	}
+	if rv := w.ResourceVersion("services"); rv != "" {
+		t.Errorf("got resource version %q before acknowledging c", rv)
+	}
+
+	// sequence numbers continue after the recovered records
+	if seq := appendEvent(t, w, "services", "d", ""); seq != 4 {
+		t.Errorf("got sequence number %d, expected 4", seq)
+	}
+	ack(t, w, 4)
+	if rv := w.ResourceVersion("services"); rv != "5" {
+		t.Errorf("got resource version %q, expected 5", rv)
+	}
+	if w.size != 0 {
+		t.Errorf("got a %d bytes log, expected it to be emptied", w.size)
+	}
+	w.Close()
+
+	w = openWAL(t, dir)
+	defer w.Close()
+	if len(w.Unacked()) != 0 {
+		t.Errorf("got %d unacknowledged records, expected none", len(w.Unacked()))
+	}
+	if rv := w.ResourceVersion("services"); rv != "5" {
+		t.Errorf("got resource version %q after reopening, expected 5", rv)
+	}
+	if seq := appendEvent(t, w, "services", "e", ""); seq != 5 {
+		t.Errorf("got sequence number %d after reopening, expected 5", seq)
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	dir, cleanup := newWALDir(t)
+	defer cleanup()
+
+	w := openWAL(t, dir)
+	// nothing stored yet
+	w.Checkpoint("pods", "1")
+	ack(t, w, 0)
+
+	s1 := appendEvent(t, w, "services", "a", "3")
+	w.Checkpoint("pods", "7")
+	s2 := appendEvent(t, w, "services", "b", "4")
+	w.Checkpoint("endpoints", "9")
+
+	for _, test := range []struct {
+		seq      uint64
+		expected map[string]string
+	}{
+		{0, map[string]string{"services": "", "pods": "1", "endpoints": ""}},
+		{s1, map[string]string{"services": "3", "pods": "7", "endpoints": ""}},
+		{s2, map[string]string{"services": "4", "pods": "7", "endpoints": "9"}},
+	} {
+		ack(t, w, test.seq)
+		for resource, expected := range test.expected {
+			if rv := w.ResourceVersion(resource); rv != expected {
+				t.Errorf("acknowledged up to %d: got %s resource version %q, expected %q", test.seq, resource, rv, expected)
+			}
+		}
+	}
+	w.Close()
+
+	w = openWAL(t, dir)
+	defer w.Close()
+	for resource, expected := range map[string]string{"services": "4", "pods": "7", "endpoints": "9"} {
+		if rv := w.ResourceVersion(resource); rv != expected {
+			t.Errorf("got %s resource version %q after reopening, expected %q", resource, rv, expected)
+		}
+	}
+}
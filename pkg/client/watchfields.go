@@ -0,0 +1,45 @@
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/glerchundi/kubelistener/pkg/client/fieldpath"
+	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+// changedFields returns the paths whose value differs between old and obj,
+// in the order they were given.
+func changedFields(paths []fieldpath.Path, old, obj kruntime.Object) ([]string, error) {
+	oldDoc, err := ToDocument(old)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := ToDocument(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, p := range paths {
+		oldValue, oldOk := p.Get(oldDoc)
+		value, ok := p.Get(doc)
+		if oldOk != ok || !reflect.DeepEqual(oldValue, value) {
+			changed = append(changed, p.String())
+		}
+	}
+	return changed, nil
+}
+
+// ToDocument returns obj as decoded from its JSON representation.
+func ToDocument(obj kruntime.Object) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	kclient "github.com/glerchundi/kubelistener/pkg/client"
+	"github.com/glerchundi/kubelistener/pkg/client/api/meta"
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+// Event is the unit of data delivered to every sink, it is also the envelope
+// serialized by the json and yaml output formats.
+type Event struct {
+	// Type of change, one of ADDED, MODIFIED, DELETED, ENTERED or LEFT.
+	Type kapi.EventType `json:"type"`
+	// Kind of the object, e.g. 'Service'.
+	Kind string `json:"kind"`
+	// Namespace of the object, empty for cluster scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the object.
+	Name string `json:"name"`
+	// ResourceVersion of the object when this event was generated.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Timestamp in which this event was received.
+	Timestamp time.Time `json:"timestamp"`
+	// Tombstone is set on deletions missed by the watch and detected on a
+	// resync, Object holds the last known state.
+	Tombstone bool `json:"tombstone,omitempty"`
+	// ChangedFields lists the watched fields a MODIFIED event changed.
+	ChangedFields []string `json:"changedFields,omitempty"`
+	// Patch from the previous to the new state of a MODIFIED object, if
+	// requested, and its type.
+	Patch     json.RawMessage `json:"patch,omitempty"`
+	PatchType string          `json:"patchType,omitempty"`
+	// Object affected by the change.
+	Object kruntime.Object `json:"object"`
+	// Previous state of a MODIFIED object.
+	Previous kruntime.Object `json:"-"`
+}
+
+func newEvent(ce *kclient.Event) *Event {
+	e := &Event{
+		Type:          ce.Type,
+		Kind:          ce.Kind,
+		Timestamp:     time.Now().UTC(),
+		Tombstone:     ce.Tombstone,
+		ChangedFields: ce.Changed,
+		Object:        ce.Object,
+		Previous:      ce.Previous,
+	}
+
+	if e.Kind == "" {
+		e.Kind = kindOf(ce.Object)
+	}
+
+	if m, err := meta.Accessor(ce.Object); err == nil {
+		e.Namespace = m.GetNamespace()
+		e.Name = m.GetName()
+		e.ResourceVersion = m.GetResourceVersion()
+	}
+
+	return e
+}
+
+// kindOf returns the kind reported by the server falling back to the name
+// of the Go type, list items do not carry type metadata.
+func kindOf(obj kruntime.Object) string {
+	if obj == nil {
+		return ""
+	}
+	if t, err := meta.TypeAccessor(obj); err == nil && t.GetKind() != "" {
+		return t.GetKind()
+	}
+	return reflect.Indirect(reflect.ValueOf(obj)).Type().Name()
+}
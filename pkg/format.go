@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	kclient "github.com/glerchundi/kubelistener/pkg/client"
+	"github.com/glerchundi/kubelistener/pkg/client/api/meta"
+	"github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v2"
+)
+
+// Formatter serializes events for line oriented outputs.
+type Formatter interface {
+	Format(w io.Writer, e *Event) error
+}
+
+// newFormatter creates a formatter from its specification: 'json', 'yaml',
+// 'diff' or 'template=<file>'.
+func newFormatter(spec string) (Formatter, error) {
+	name, arg := spec, ""
+	if i := strings.Index(spec, "="); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+
+	switch name {
+	case "json":
+		return &jsonFormatter{}, nil
+	case "yaml":
+		return &yamlFormatter{}, nil
+	case "diff":
+		return &diffFormatter{}, nil
+	case "template":
+		if arg == "" {
+			return nil, fmt.Errorf("template output format requires a file, e.g. 'template=/etc/kubelistener/event.tmpl'")
+		}
+		return newTemplateFormatter(arg)
+	}
+
+	return nil, fmt.Errorf("unknown output format '%s', valid ones are: json, yaml, diff, template=<file>", spec)
+}
+
+// jsonFormatter writes one envelope per line.
+type jsonFormatter struct{}
+
+func (*jsonFormatter) Format(w io.Writer, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// yamlFormatter writes one document per envelope.
+type yamlFormatter struct{}
+
+func (*yamlFormatter) Format(w io.Writer, e *Event) error {
+	// API objects only define json tags, go through json to honor them
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	data, err = yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "---\n"); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// diffFormatter writes a summary line per envelope followed, for MODIFIED
+// objects, by a unified diff of their yaml representations.
+type diffFormatter struct{}
+
+func (*diffFormatter) Format(w io.Writer, e *Event) error {
+	name := e.Name
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + name
+	}
+	if _, err := fmt.Fprintf(w, "%s %s %s\n", e.Type, e.Kind, name); err != nil {
+		return err
+	}
+	if e.Previous == nil {
+		return nil
+	}
+
+	old, err := toYAML(e.Previous)
+	if err != nil {
+		return err
+	}
+	cur, err := toYAML(e.Object)
+	if err != nil {
+		return err
+	}
+	oldVersion := ""
+	if m, err := meta.Accessor(e.Previous); err == nil {
+		oldVersion = m.GetResourceVersion()
+	}
+
+	return difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+		A:        difflib.SplitLines(old),
+		B:        difflib.SplitLines(cur),
+		FromFile: name + "@" + oldVersion,
+		ToFile:   name + "@" + e.ResourceVersion,
+		Context:  3,
+	})
+}
+
+// toYAML renders obj as yaml, going through json to honor its tags.
+func toYAML(obj kruntime.Object) (string, error) {
+	doc, err := kclient.ToDocument(obj)
+	if err != nil {
+		return "", err
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// templateFormatter executes a user-provided text/template per envelope.
+type templateFormatter struct {
+	tmpl *template.Template
+}
+
+func newTemplateFormatter(path string) (*templateFormatter, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template: %v", err)
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template: %v", err)
+	}
+
+	return &templateFormatter{tmpl}, nil
+}
+
+func (f *templateFormatter) Format(w io.Writer, e *Event) error {
+	return f.tmpl.Execute(w, e)
+}
+
+// templateFuncs are the helpers available to user-provided templates.
+var templateFuncs = template.FuncMap{
+	"json":          templateJSON,
+	"quantity":      templateQuantity,
+	"quantityValue": templateQuantityValue,
+	"quantityMilli": templateQuantityMilli,
+	"intOrString":   templateIntOrString,
+	"labels":        templateLabels,
+	"label":         templateLabel,
+	"formatTime":    templateFormatTime,
+	"age":           templateAge,
+}
+
+func templateJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func toQuantity(v interface{}) (*kapi.Quantity, error) {
+	switch q := v.(type) {
+	case kapi.Quantity:
+		return &q, nil
+	case *kapi.Quantity:
+		return q, nil
+	}
+	return nil, fmt.Errorf("expected a quantity, got %T", v)
+}
+
+// templateQuantity returns the canonical form of a quantity, e.g. '500m'.
+func templateQuantity(v interface{}) (string, error) {
+	q, err := toQuantity(v)
+	if err != nil || q == nil {
+		return "", err
+	}
+	return q.String(), nil
+}
+
+// templateQuantityValue returns a quantity rounded up to an integer.
+func templateQuantityValue(v interface{}) (int64, error) {
+	q, err := toQuantity(v)
+	if err != nil || q == nil {
+		return 0, err
+	}
+	return q.Value(), nil
+}
+
+// templateQuantityMilli returns a quantity in thousandths.
+func templateQuantityMilli(v interface{}) (int64, error) {
+	q, err := toQuantity(v)
+	if err != nil || q == nil {
+		return 0, err
+	}
+	return q.MilliValue(), nil
+}
+
+func templateIntOrString(v interface{}) (string, error) {
+	switch i := v.(type) {
+	case kapi.IntOrString:
+		return i.String(), nil
+	case *kapi.IntOrString:
+		if i == nil {
+			return "", nil
+		}
+		return i.String(), nil
+	}
+	return "", fmt.Errorf("expected an int or string, got %T", v)
+}
+
+// templateLabels returns labels in selector form: 'k1=v1,k2=v2'.
+func templateLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + labels[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+func templateLabel(key string, labels map[string]string) string {
+	return labels[key]
+}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case unversioned.Time:
+		return t.Time, nil
+	case *unversioned.Time:
+		if t == nil {
+			return time.Time{}, nil
+		}
+		return t.Time, nil
+	case time.Time:
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected a time, got %T", v)
+}
+
+// templateFormatTime formats a time using a Go layout, it is meant to be
+// used in pipelines: '{{.Object.CreationTimestamp | formatTime "2006-01-02"}}'.
+func templateFormatTime(layout string, v interface{}) (string, error) {
+	t, err := toTime(v)
+	if err != nil || t.IsZero() {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+// templateAge returns the time elapsed since v, rounded to seconds.
+func templateAge(v interface{}) (string, error) {
+	t, err := toTime(v)
+	if err != nil || t.IsZero() {
+		return "", err
+	}
+	return (time.Since(t) / time.Second * time.Second).String(), nil
+}
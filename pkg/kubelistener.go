@@ -2,24 +2,63 @@ package pkg
 
 import (
 	"fmt"
-	"io"
-	"io/ioutil"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"golang.org/x/net/context"
 	log "github.com/glerchundi/logrus"
 	kclient "github.com/glerchundi/kubelistener/pkg/client"
-	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
 )
 
 type Config struct {
 	KubeMasterURL string
+	KubeConfig string
+	KubeContext string
+	TokenFile string
+	ClientCertificate string
+	ClientKey string
+	Username string
+	PasswordFile string
+	CertificateAuthority string
+	CredentialsReloadInterval time.Duration
 	Namespace string
+	AllNamespaces bool
+	IncludeNamespaces []string
+	ExcludeNamespaces []string
 	Resource string
 	Selector string
+	FieldSelector string
+	ClientSelector string
+	WatchFields []string
+	Dedupe bool
+	IgnoreFields []string
+	Patch string
 	ResyncInterval time.Duration
+	PollInterval time.Duration
+	BackoffInitialDelay time.Duration
+	BackoffMaxDelay time.Duration
+	BackoffResetAfter time.Duration
+	BufferSize int
+	Backpressure string
+	SpillDir string
+	SpillLimit int64
+	WALDir string
+	DeliveryAttempts int
+	FlushEvents int
+	FlushInterval time.Duration
+	DrainTimeout time.Duration
+	Sinks []string
+	OutputFormat string
+	WebhookTimeout time.Duration
+	WebhookRetries int
+	WebhookBackoff time.Duration
+	WebhookHeaders []string
+	WebhookSecretFile string
 	AddEventsFile string
 	UpdateEventsFile string
 	DeleteEventsFile string
@@ -28,160 +67,519 @@ type Config struct {
 func NewConfig() *Config {
 	return &Config{
 		KubeMasterURL: "",
+		KubeConfig: "",
+		KubeContext: "",
+		TokenFile: "",
+		ClientCertificate: "",
+		ClientKey: "",
+		Username: "",
+		PasswordFile: "",
+		CertificateAuthority: "",
+		CredentialsReloadInterval: 1 * time.Minute,
 		Namespace: "",
+		AllNamespaces: false,
+		IncludeNamespaces: []string{},
+		ExcludeNamespaces: []string{},
 		Resource: "services",
 		Selector: "",
+		FieldSelector: "",
+		ClientSelector: "",
+		WatchFields: []string{},
+		Dedupe: false,
+		IgnoreFields: []string{},
+		Patch: "",
 		ResyncInterval: 30 * time.Minute,
-		AddEventsFile: "/dev/stdout",
-		UpdateEventsFile: "/dev/stdout",
-		DeleteEventsFile: "/dev/stdout",
+		PollInterval: 1 * time.Minute,
+		BackoffInitialDelay: 1 * time.Second,
+		BackoffMaxDelay: 2 * time.Minute,
+		BackoffResetAfter: 5 * time.Minute,
+		BufferSize: 100,
+		Backpressure: string(kclient.Block),
+		SpillDir: filepath.Join(os.TempDir(), "kubelistener"),
+		SpillLimit: 64 * 1024 * 1024,
+		WALDir: "",
+		DeliveryAttempts: 10,
+		FlushEvents: 1000,
+		FlushInterval: 5 * time.Second,
+		DrainTimeout: 30 * time.Second,
+		Sinks: []string{},
+		OutputFormat: "json",
+		WebhookTimeout: 10 * time.Second,
+		WebhookRetries: 5,
+		WebhookBackoff: 500 * time.Millisecond,
+		WebhookHeaders: []string{},
+		WebhookSecretFile: "",
+		AddEventsFile: "",
+		UpdateEventsFile: "",
+		DeleteEventsFile: "",
 	}
 }
 
 type KubeListener struct {
 	// Configuration
 	config *Config
-	// Writer for 'add' events
-	addWriter io.Writer
-	// Writer for 'update' events
-	updateWriter io.Writer
-	// Writer for 'delete' events
-	deleteWriter io.Writer
+	// Outputs
+	sinks sinkSet
+	patchType string
+	// Write-ahead log, if enabled, and the last sequence number delivered
+	wal *kclient.WAL
+	delivered uint64
+	// set once an event could not be delivered, nothing else is acknowledged
+	stalled bool
+	// events handled since the last flush and when it happened
+	unflushed int
+	flushed time.Time
 }
 
 func NewKubeListener(config *Config) *KubeListener {
 	return &KubeListener{config:config}
 }
 
-func process(v interface{}) {
-	switch vv := v.(type) {
-		case *kapi.Service:
-		fmt.Printf("*SERVICE:     %v\n\n", vv)
-		case *kapi.ServiceList:
-		fmt.Printf("*SERVICELIST: %v\n\n", vv)
-		case *kapi.WatchEvent:
-		fmt.Printf("*WATCHEVENT:  %v\n", vv)
-		fmt.Printf("*WATCHEVENT (VALUE): %v\n", vv.Object)
-		default:
-		fmt.Printf("DEFAULT:      %v\n\n", vv)
+// sinkURIs returns the configured sinks plus the ones derived from the
+// per-event-type files. Event types sharing a path share the same sink.
+func (c *Config) sinkURIs() []string {
+	uris := append([]string{}, c.Sinks...)
+
+	var paths []string
+	types := make(map[string][]string)
+	for _, f := range []struct{ eventType, path string }{
+		{"added", c.AddEventsFile},
+		{"modified", c.UpdateEventsFile},
+		{"deleted", c.DeleteEventsFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		path := filepath.Clean(f.path)
+		if _, ok := types[path]; !ok {
+			paths = append(paths, path)
+		}
+		types[path] = append(types[path], f.eventType)
+	}
+
+	for _, path := range paths {
+		u := &url.URL{Scheme: "file", Path: path}
+		switch path {
+		case "/dev/stdout":
+			u = &url.URL{Scheme: "stdout"}
+		case "/dev/stderr":
+			u = &url.URL{Scheme: "stderr"}
+		}
+		u.RawQuery = url.Values{"events": {strings.Join(types[path], ",")}}.Encode()
+		uris = append(uris, u.String())
+	}
+
+	// print everything if no output was provided
+	if len(uris) == 0 {
+		uris = append(uris, "stdout://")
+	}
+
+	return uris
+}
+
+// event returns the envelope delivered to the sinks for e.
+func (kl *KubeListener) event(e *kclient.Event) *Event {
+	ev := newEvent(e)
+	if err := ev.attachPatch(kl.patchType); err != nil {
+		log.Errorf("unable to compute patch for %s %s/%s: %v", ev.Kind, ev.Namespace, ev.Name, err)
+	}
+	return ev
+}
+
+func (kl *KubeListener) handle(ctx context.Context, e *kclient.Event) {
+	if e.Type == kclient.Bookmark {
+		if kl.wal != nil {
+			kl.wal.Checkpoint(e.Resource, e.ResumeVersion)
+		}
+		return
+	}
+
+	if kl.wal == nil {
+		if err := kl.sinks.Emit(ctx, kl.event(e)); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	seq, err := kl.wal.Append(e)
+	if err != nil {
+		log.Errorf("unable to append %s event to the write-ahead log, delivering it anyway: %v", e.Type, err)
+	}
+	kl.deliver(ctx, seq, kl.event(e))
+}
+
+// deliver emits e until every sink accepting it took it or ctx is done. In
+// the latter case nothing else is acknowledged, so it is redelivered after
+// a restart. Sinks failing permanently, or more times than allowed, are
+// given up on and e is acknowledged anyway.
+func (kl *KubeListener) deliver(ctx context.Context, seq uint64, e *Event) {
+	sinks := kl.sinks
+	delay := kl.config.BackoffInitialDelay
+	for attempt := 1; ; attempt++ {
+		failed, err := sinks.emit(ctx, e)
+		if err == nil {
+			break
+		}
+		log.Error(err)
+
+		if len(failed) == 0 {
+			log.Errorf("Discarding %s %s event of %s/%s at resource version %s, rejected by the sinks", e.Kind, e.Type, e.Namespace, e.Name, e.ResourceVersion)
+			break
+		}
+
+		if ctx.Err() != nil {
+			log.Warnf("%s %s event left unacknowledged, it will be redelivered on restart", e.Kind, e.Type)
+			kl.stalled = true
+			return
+		}
+
+		if kl.config.DeliveryAttempts > 0 && attempt >= kl.config.DeliveryAttempts {
+			log.Errorf("Discarding %s %s event of %s/%s at resource version %s, %d sinks failed %d times", e.Kind, e.Type, e.Namespace, e.Name, e.ResourceVersion, len(failed), attempt)
+			break
+		}
+
+		log.Warnf("Retrying delivery to %d sinks in %v", len(failed), delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		sinks = failed
+		if delay *= 2; delay > kl.config.BackoffMaxDelay {
+			delay = kl.config.BackoffMaxDelay
+		}
+	}
+
+	if seq > 0 {
+		kl.delivered = seq
 	}
 }
 
+// flushDue reports whether enough events were handled, or time went by,
+// since the last flush to force one even if more are queued.
+func (kl *KubeListener) flushDue() bool {
+	if kl.config.FlushEvents > 0 && kl.unflushed >= kl.config.FlushEvents {
+		return true
+	}
+	return kl.config.FlushInterval > 0 && time.Since(kl.flushed) >= kl.config.FlushInterval
+}
+
+// flush flushes the outputs and acknowledges the events they delivered.
+func (kl *KubeListener) flush() {
+	kl.unflushed = 0
+	kl.flushed = time.Now()
+	if err := kl.sinks.Flush(); err != nil {
+		log.Error(err)
+		return
+	}
+	if kl.wal != nil && !kl.stalled {
+		if err := kl.wal.Ack(kl.delivered); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// clientConfig returns how to reach the cluster and the namespace to watch.
+// Credentials are loaded from the kubeconfig if given or, unless given
+// through flags, from the in-cluster service account. Flags take precedence.
+func (kl *KubeListener) clientConfig() (*kclient.ClientConfig, string, error) {
+	auth, err := kl.flagAuth()
+	if err != nil {
+		return nil, "", err
+	}
+
+	config := &kclient.ClientConfig{MasterURL: kl.config.KubeMasterURL}
+	namespace := kl.config.Namespace
+	switch {
+	case kl.config.KubeConfig != "":
+		var contextNamespace string
+		config, contextNamespace, err = kclient.LoadKubeConfig(kl.config.KubeConfig, kl.config.KubeContext)
+		if err != nil {
+			return nil, "", err
+		}
+		if kl.config.KubeMasterURL != "" {
+			config.MasterURL = kl.config.KubeMasterURL
+		}
+		if namespace == "" && !kl.config.AllNamespaces {
+			namespace = contextNamespace
+		}
+	case kl.config.KubeContext != "":
+		return nil, "", fmt.Errorf("--context requires --kubeconfig")
+	case auth == nil:
+		// Use the service account token and CA certificate
+		config.Auth = &kclient.TokenAuth{TokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token"}
+		config.CaCertificateFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	}
+
+	if auth != nil {
+		config.Auth = auth
+	}
+	if kl.config.CertificateAuthority != "" {
+		config.CaCertificateFile = kl.config.CertificateAuthority
+	}
+
+	return config, namespace, nil
+}
+
+// flagAuth returns the credentials given through flags, nil if none. Files
+// are read by the client so they can be reloaded.
+func (kl *KubeListener) flagAuth() (kclient.ClientAuth, error) {
+	c := kl.config
+	modes := 0
+	for _, set := range []bool{
+		c.ClientCertificate != "" || c.ClientKey != "",
+		c.TokenFile != "",
+		c.Username != "" || c.PasswordFile != "",
+	} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, fmt.Errorf("only one of --client-certificate, --token-file or --username can be given")
+	}
+
+	switch {
+	case c.ClientCertificate != "" || c.ClientKey != "":
+		if c.ClientCertificate == "" || c.ClientKey == "" {
+			return nil, fmt.Errorf("--client-certificate and --client-key must be given together")
+		}
+		return &kclient.ClientCertificateAuth{ClientCertificateFile: c.ClientCertificate, ClientKeyFile: c.ClientKey}, nil
+	case c.TokenFile != "":
+		return &kclient.TokenAuth{TokenFile: c.TokenFile}, nil
+	case c.Username != "" || c.PasswordFile != "":
+		if c.Username == "" || c.PasswordFile == "" {
+			return nil, fmt.Errorf("--username and --password-file must be given together")
+		}
+		return &kclient.UsernameAndPasswordAuth{Username: c.Username, PasswordFile: c.PasswordFile}, nil
+	}
+	return nil, nil
+}
+
 func (kl *KubeListener) Run() {
-	// Get service account token
-	serviceAccountToken, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
+	patchType, err := parsePatchFormat(kl.config.Patch)
 	if err != nil {
 		log.Fatal(err)
 	}
+	kl.patchType = patchType
 
-	// Get CA certificate data
-	caCertificate, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+	// Dropped events never reach the write-ahead log, yet later ones would
+	// be acknowledged past them
+	if kl.config.WALDir != "" {
+		switch kclient.BackpressurePolicy(kl.config.Backpressure) {
+		case kclient.DropNewest, kclient.DropOldest:
+			log.Fatalf("--wal-dir can not be combined with the %s backpressure policy", kl.config.Backpressure)
+		}
+	}
+
+	// retries would spin otherwise
+	if kl.config.BackoffInitialDelay <= 0 || kl.config.BackoffMaxDelay <= 0 {
+		log.Fatalf("--backoff-initial-delay and --backoff-max-delay must be positive")
+	}
+
+	// Open outputs
+	sinks, err := newSinkSet(kl.config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := sinks.Open(); err != nil {
+		log.Fatal(err)
+	}
+	kl.sinks = sinks
 
-	// Create new k8s client
-	kubeConfig := &kclient.ClientConfig{
-		MasterURL: kl.config.KubeMasterURL,
-		Auth: &kclient.TokenAuth{ string(serviceAccountToken) },
-		CaCertificate: caCertificate,
+	// Stop on signals
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-signalChan
+		log.Infof("Captured %v. Exiting...", s)
+		cancel()
+		// don't wait for the drain if asked twice
+		s = <-signalChan
+		log.Warnf("Captured %v again. Exiting without draining...", s)
+		os.Exit(1)
+	}()
+
+	// Redeliver what a previous run left unacknowledged
+	if kl.config.WALDir != "" {
+		wal, err := kclient.OpenWAL(kl.config.WALDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		kl.wal = wal
+
+		if records := wal.Unacked(); len(records) > 0 {
+			log.Infof("Redelivering %d unacknowledged events...", len(records))
+			for _, r := range records {
+				kl.deliver(ctx, r.Seq, kl.event(r.Event))
+			}
+			kl.flush()
+		}
 	}
+
+	// Load credentials
+	kubeConfig, namespace, err := kl.clientConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create new k8s client
 	kubeClient, err := kclient.NewClient(kubeConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Flow control channels
-	recvChan := make(chan interface{}, 100)
-	stopChan := make(<-chan struct {})
-	doneChan := make(chan bool)
-	errChan := make(chan error, 10)
+	// Pick up rotated credentials
+	if kl.config.CredentialsReloadInterval > 0 {
+		go kubeClient.Credentials().Watch(ctx, kl.config.CredentialsReloadInterval)
+	}
 
-	// Create informer from client
-	informerConfig := &kclient.InformerConfig{
-		Namespace: kl.config.Namespace,
-		Resource: kl.config.Resource,
-		Selector: kl.config.Selector,
-		ResyncInterval: kl.config.ResyncInterval,
-	}
-	i, err := kubeClient.NewInformer(
-		informerConfig, recvChan,
-		stopChan, doneChan, errChan,
-	)
+	// Resolve resources against the ones served, or the builtin ones if the
+	// server can not tell
+	parseResources := kclient.ParseResources
+	if discovery, err := kubeClient.Discover(ctx); err != nil {
+		log.Warnf("Unable to discover the served resources, using the builtin ones: %v", err)
+	} else {
+		parseResources = discovery.ParseResources
+	}
+	resources, err := parseResources(kl.config.Resource)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	go i.Run()
+	// Flow control
+	policy, err := kclient.ParseBackpressurePolicy(kl.config.Backpressure)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue, err := kclient.NewQueue(&kclient.QueueConfig{
+		Size: kl.config.BufferSize,
+		Policy: policy,
+		SpillDir: kl.config.SpillDir,
+		SpillLimit: kl.config.SpillLimit,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	errChan := make(chan error, 10)
 
-	// Wait for signal
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	// Create one informer per resource, all of them feeding the same queue
+	var informers []*kclient.Informer
+	for _, resource := range resources {
+		informerConfig := &kclient.InformerConfig{
+			Namespace: namespace,
+			AllNamespaces: kl.config.AllNamespaces,
+			NamespaceFilter: &kclient.NamespaceFilter{
+				Include: kl.config.IncludeNamespaces,
+				Exclude: kl.config.ExcludeNamespaces,
+			},
+			Resource: resource,
+			Selector: kl.config.Selector,
+			FieldSelector: kl.config.FieldSelector,
+			ClientSelector: kl.config.ClientSelector,
+			WatchFields: kl.config.WatchFields,
+			Dedupe: kl.config.Dedupe,
+			IgnoreFields: kl.config.IgnoreFields,
+			ResyncInterval: kl.config.ResyncInterval,
+			PollInterval: kl.config.PollInterval,
+			Backoff: &kclient.BackoffConfig{
+				InitialDelay: kl.config.BackoffInitialDelay,
+				MaxDelay: kl.config.BackoffMaxDelay,
+				ResetAfter: kl.config.BackoffResetAfter,
+			},
+		}
+		if kl.wal != nil {
+			informerConfig.ResourceVersion = kl.wal.ResourceVersion(resource)
+			informerConfig.Bookmarks = true
+		}
+		i, err := kubeClient.NewInformer(informerConfig, queue, errChan)
+		if err != nil {
+			log.Fatal(err)
+		}
+		informers = append(informers, i)
+	}
+
+	// Informers share their lifecycle, once one returns the rest are stopped
+	runErrChan := make(chan error, len(informers))
+	for _, i := range informers {
+		go func(i *kclient.Informer) {
+			runErrChan <- i.Run(ctx)
+		}(i)
+	}
+	log.Infof("Watching %s", strings.Join(resources, ", "))
+
+	running := len(informers)
+	var runErr error
 	for {
 		select {
-		case v := <-recvChan:
-			log.Infof("%v", v)
+		case v := <-queue.C():
+			kl.handle(ctx, v)
+			kl.unflushed++
+			// flush once there is nothing else pending, or often enough
+			// to bound what a crash redelivers under sustained load
+			if queue.Len() == 0 || kl.flushDue() {
+				kl.flush()
+			}
 		case err := <-errChan:
 			log.Error(err)
-		case s := <-signalChan:
-			log.Infof("Captured %v. Exiting...", s)
-			close(doneChan)
-		case <-doneChan:
-			os.Exit(0)
+		case err := <-runErrChan:
+			if err != nil {
+				log.Error(err)
+				if runErr == nil {
+					runErr = err
+				}
+			}
+			cancel()
+			if running--; running > 0 {
+				continue
+			}
+			kl.shutdown(queue, informers, errChan)
+			if runErr != nil {
+				// exit non-zero
+				log.Fatalf("Stopped after an informer failed: %v", runErr)
+			}
+			return
 		}
 	}
+}
 
-	/*
-	// create kubelistener instance
-	kl := kubelistener{config: config}
-
-	// open add events files
-	if config.AddEventsFile == "" {
-		glog.Warningf("Ignoring 'add' events because --add-events-file wasn't provided.")
-	} else {
-		if w, err := newWriter(config.AddEventsFile); err != nil {
-			glog.Fatalf("Unable to open '%s' for writing due to: %s", config.AddEventsFile, err.Error())
-		} else {
-			kl.addWriter = w
-		}
+// shutdown delivers the events still queued once the informers are stopped,
+// for up to the drain timeout, and closes the outputs.
+func (kl *KubeListener) shutdown(queue *kclient.Queue, informers []*kclient.Informer, errChan chan error) {
+	// the informers context is done by now, deliveries get their own
+	ctx, cancel := context.WithTimeout(context.Background(), kl.config.DrainTimeout)
+	defer cancel()
+	log.Infof("Draining %d queued events...", queue.Len())
+	for queue.Len() > 0 && ctx.Err() == nil {
+		kl.handle(ctx, <-queue.C())
 	}
-
-	// open update events files
-	if config.UpdateEventsFile == "" {
-		glog.Warningf("Ignoring 'update' events because --update-events-file wasn't provided.")
-	} else {
-		if w, err := newWriter(config.UpdateEventsFile); err != nil {
-			glog.Fatalf("Unable to open '%s' for writing due to: %s", config.UpdateEventsFile, err.Error())
-		} else {
-			kl.updateWriter = w
-		}
+	if n := queue.Len(); n > 0 {
+		log.Warnf("Drain timed out, discarding %d queued events", n)
+	}
+	kl.flush()
+	for len(errChan) > 0 {
+		log.Error(<-errChan)
 	}
 
-	// open delete events files
-	if config.DeleteEventsFile == "" {
-		glog.Warningf("Ignoring 'delete' events because --delete-events-file wasn't provided.")
-	} else {
-		if w, err := newWriter(config.DeleteEventsFile); err != nil {
-			glog.Fatalf("Unable to open '%s' for writing due to: %s", config.DeleteEventsFile, err.Error())
-		} else {
-			kl.deleteWriter = w
-		}
+	stats := queue.Stats()
+	log.Infof("Backpressure stats: %d newest dropped, %d oldest dropped, %d spilled", stats.DroppedNewest, stats.DroppedOldest, stats.Spilled)
+	var suppressed uint64
+	for _, i := range informers {
+		suppressed += i.Suppressed()
+	}
+	log.Infof("Dedupe stats: %d no-op updates suppressed", suppressed)
+	if err := queue.Close(); err != nil {
+		log.Error(err)
 	}
 
-	// choose which resources to watch
-	if config.Resource == "" {
-		glog.Fatalf("Unable to start kubelistener because --resources to watch wasn't provided.")
+	if err := kl.sinks.Close(); err != nil {
+		log.Error(err)
 	}
 
-	var resource = strings.ToLower(config.Resource)
-	if resource == "all" {
-		for _, watchFunc := range resources {
-			watchFunc(&kl)
+	if kl.wal != nil {
+		if err := kl.wal.Close(); err != nil {
+			log.Error(err)
 		}
-	} else if watchFunc, ok := resources[resource]; ok {
-		watchFunc(&kl)
-	} else {
-		glog.Fatal("Unknown resource to watch '%s':", resource)
 	}
-	*/
 }
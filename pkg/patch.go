@@ -0,0 +1,175 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	kclient "github.com/glerchundi/kubelistener/pkg/client"
+)
+
+// Patch types, as understood by the API server.
+const (
+	JSONPatchType  = "application/json-patch+json"
+	MergePatchType = "application/merge-patch+json"
+)
+
+// patchTypes maps the accepted --patch values to their patch type.
+var patchTypes = map[string]string{
+	"json":  JSONPatchType,
+	"merge": MergePatchType,
+}
+
+// parsePatchFormat returns the patch type for format, empty if no patch
+// is wanted.
+func parsePatchFormat(format string) (string, error) {
+	if format == "" {
+		return "", nil
+	}
+	if t, ok := patchTypes[format]; ok {
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown patch format '%s', valid ones are: json, merge", format)
+}
+
+// attachPatch sets the patch from the previous to the new state of a
+// MODIFIED event.
+func (e *Event) attachPatch(patchType string) error {
+	if patchType == "" || e.Previous == nil {
+		return nil
+	}
+
+	old, err := kclient.ToDocument(e.Previous)
+	if err != nil {
+		return err
+	}
+	doc, err := kclient.ToDocument(e.Object)
+	if err != nil {
+		return err
+	}
+
+	var patch interface{}
+	switch patchType {
+	case JSONPatchType:
+		patch = jsonPatch("", old, doc, []patchOperation{})
+	case MergePatchType:
+		patch = mergePatch(old, doc)
+	default:
+		return fmt.Errorf("unknown patch type '%s'", patchType)
+	}
+
+	if e.Patch, err = json.Marshal(patch); err != nil {
+		return err
+	}
+	e.PatchType = patchType
+	return nil
+}
+
+// patchOperation is an RFC 6902 operation.
+type patchOperation struct {
+	Op    string
+	Path  string
+	Value interface{}
+}
+
+func (o patchOperation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(map[string]string{"op": o.Op, "path": o.Path})
+	}
+	// the value is mandatory even if null
+	return json.Marshal(map[string]interface{}{"op": o.Op, "path": o.Path, "value": o.Value})
+}
+
+// jsonPatch appends to ops the RFC 6902 operations transforming a into b,
+// both located at path.
+func jsonPatch(path string, a, b interface{}, ops []patchOperation) []patchOperation {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, k := range sortedKeys(av) {
+			if _, ok := bv[k]; !ok {
+				ops = append(ops, patchOperation{Op: "remove", Path: path + "/" + escapePointer(k)})
+			}
+		}
+		for _, k := range sortedKeys(bv) {
+			p := path + "/" + escapePointer(k)
+			if _, ok := av[k]; !ok {
+				ops = append(ops, patchOperation{Op: "add", Path: p, Value: bv[k]})
+				continue
+			}
+			ops = jsonPatch(p, av[k], bv[k], ops)
+		}
+		return ops
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		n := len(av)
+		if len(bv) < n {
+			n = len(bv)
+		}
+		for i := 0; i < n; i++ {
+			ops = jsonPatch(path+"/"+strconv.Itoa(i), av[i], bv[i], ops)
+		}
+		for i := n; i < len(bv); i++ {
+			ops = append(ops, patchOperation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: bv[i]})
+		}
+		// remove from the end so indexes stay valid
+		for i := len(av) - 1; i >= n; i-- {
+			ops = append(ops, patchOperation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		return ops
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		ops = append(ops, patchOperation{Op: "replace", Path: path, Value: b})
+	}
+	return ops
+}
+
+// mergePatch returns the RFC 7386 merge patch transforming a into b.
+func mergePatch(a, b interface{}) interface{} {
+	av, aok := a.(map[string]interface{})
+	bv, bok := b.(map[string]interface{})
+	if !aok || !bok {
+		return b
+	}
+
+	patch := make(map[string]interface{})
+	for k := range av {
+		if _, ok := bv[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, v := range bv {
+		old, ok := av[k]
+		switch {
+		case !ok:
+			patch[k] = v
+		case !reflect.DeepEqual(old, v):
+			patch[k] = mergePatch(old, v)
+		}
+	}
+	return patch
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes a key for use in an RFC 6901 JSON pointer.
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,228 @@
+package pkg
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+// patchCases are pairs of documents, as decoded from JSON, to patch one into
+// the other.
+var patchCases = []struct {
+	name string
+	a, b string
+}{
+	{"identical", `{"a":1,"b":{"c":[1,2]}}`, `{"a":1,"b":{"c":[1,2]}}`},
+	{"replaced value", `{"a":1,"b":"x"}`, `{"a":2,"b":"x"}`},
+	{"added key", `{"a":1}`, `{"a":1,"b":{"c":true}}`},
+	{"removed key", `{"a":1,"b":{"c":true,"d":null}}`, `{"a":1,"b":{"d":null}}`},
+	{"escaped keys", `{"labels":{"example.com/tier":"web","a~b":"1","a~1b":"2"}}`, `{"labels":{"example.com/tier":"db","a~b":"3"}}`},
+	{"array grown", `{"ports":[{"port":80}]}`, `{"ports":[{"port":80},{"port":443}]}`},
+	{"array shrunk", `{"ports":[1,2,3,4]}`, `{"ports":[1]}`},
+	{"array element changed", `{"ports":[{"port":80,"name":"http"}]}`, `{"ports":[{"port":8080}]}`},
+	{"type changed", `{"a":{"b":1}}`, `{"a":[1]}`},
+}
+
+func decodeDocument(t *testing.T, s string) interface{} {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// roundTrip returns v as decoded from its JSON representation, the way a
+// patch is received.
+func roundTrip(t *testing.T, v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestJSONPatch(t *testing.T) {
+	for _, test := range patchCases {
+		a, b := decodeDocument(t, test.a), decodeDocument(t, test.b)
+		ops := jsonPatch("", a, b, []patchOperation{})
+		if test.name == "identical" && len(ops) != 0 {
+			t.Errorf("%s: got %d operations, expected none", test.name, len(ops))
+		}
+
+		patched, err := applyJSONPatch(decodeDocument(t, test.a), roundTrip(t, ops).([]interface{}))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(patched, b) {
+			t.Errorf("%s: patched into %v, expected %v", test.name, patched, b)
+		}
+	}
+}
+
+func TestJSONPatchEscapesPaths(t *testing.T) {
+	ops := jsonPatch("", decodeDocument(t, `{"a/b":1,"c~d":1}`), decodeDocument(t, `{"a/b":2,"c~d":2}`), []patchOperation{})
+	var paths []string
+	for _, op := range ops {
+		paths = append(paths, op.Path)
+	}
+	if expected := []string{"/a~1b", "/c~0d"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got paths %v, expected %v", paths, expected)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	for _, test := range patchCases {
+		a, b := decodeDocument(t, test.a), decodeDocument(t, test.b)
+		patch := roundTrip(t, mergePatch(a, b))
+		if test.name == "identical" && !reflect.DeepEqual(patch, map[string]interface{}{}) {
+			t.Errorf("%s: got patch %v, expected an empty one", test.name, patch)
+		}
+
+		// nulls can not be set by a merge patch
+		if strings.Contains(test.b, "null") {
+			continue
+		}
+		if patched := applyMergePatch(decodeDocument(t, test.a), patch); !reflect.DeepEqual(patched, b) {
+			t.Errorf("%s: patched into %v, expected %v", test.name, patched, b)
+		}
+	}
+
+	patch := roundTrip(t, mergePatch(decodeDocument(t, `{"a":1,"b":{"c":true,"d":1}}`), decodeDocument(t, `{"a":1,"b":{"d":1}}`)))
+	if expected := decodeDocument(t, `{"b":{"c":null}}`); !reflect.DeepEqual(patch, expected) {
+		t.Errorf("got patch %v, expected %v", patch, expected)
+	}
+}
+
+func TestAttachPatch(t *testing.T) {
+	old := &kapi.Service{ObjectMeta: kapi.ObjectMeta{Name: "foo", ResourceVersion: "1", Labels: map[string]string{"example.com/tier": "web"}}}
+	obj := &kapi.Service{ObjectMeta: kapi.ObjectMeta{Name: "foo", ResourceVersion: "2"}}
+
+	for _, patchType := range []string{JSONPatchType, MergePatchType} {
+		e := &Event{Type: kapi.Modified, Object: obj, Previous: old}
+		if err := e.attachPatch(patchType); err != nil {
+			t.Fatal(err)
+		}
+		if e.PatchType != patchType {
+			t.Errorf("got patch type %s, expected %s", e.PatchType, patchType)
+		}
+
+		var patch interface{}
+		if err := json.Unmarshal(e.Patch, &patch); err != nil {
+			t.Fatal(err)
+		}
+		patched := roundTrip(t, old)
+		if patchType == JSONPatchType {
+			var err error
+			if patched, err = applyJSONPatch(patched, patch.([]interface{})); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			patched = applyMergePatch(patched, patch)
+		}
+		if expected := roundTrip(t, obj); !reflect.DeepEqual(patched, expected) {
+			t.Errorf("%s: patched into %v, expected %v", patchType, patched, expected)
+		}
+	}
+
+	e := &Event{Type: kapi.Added, Object: obj}
+	if err := e.attachPatch(JSONPatchType); err != nil || e.Patch != nil {
+		t.Errorf("got patch %s (%v), expected none for events without previous state", e.Patch, err)
+	}
+}
+
+// applyJSONPatch applies RFC 6902 add, remove and replace operations.
+func applyJSONPatch(doc interface{}, ops []interface{}) (interface{}, error) {
+	for _, o := range ops {
+		op := o.(map[string]interface{})
+		var tokens []string
+		if path := op["path"].(string); path != "" {
+			for _, token := range strings.Split(path, "/")[1:] {
+				tokens = append(tokens, strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1))
+			}
+		}
+		var err error
+		if doc, err = applyOperation(doc, tokens, op["op"].(string), op["value"]); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func applyOperation(doc interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		if op == "remove" {
+			return nil, nil
+		}
+		return value, nil
+	}
+
+	token, rest := tokens[0], tokens[1:]
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 && op == "remove" {
+			delete(v, token)
+			return v, nil
+		}
+		child, err := applyOperation(v[token], rest, op, value)
+		v[token] = child
+		return v, err
+	case []interface{}:
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i > len(v) {
+			return nil, errInvalidIndex(token)
+		}
+		if len(rest) == 0 {
+			switch op {
+			case "add":
+				return append(v[:i], append([]interface{}{value}, v[i:]...)...), nil
+			case "remove":
+				if i == len(v) {
+					return nil, errInvalidIndex(token)
+				}
+				return append(v[:i], v[i+1:]...), nil
+			}
+		}
+		if i == len(v) {
+			return nil, errInvalidIndex(token)
+		}
+		child, err := applyOperation(v[i], rest, op, value)
+		v[i] = child
+		return v, err
+	}
+	return nil, errInvalidIndex(token)
+}
+
+type errInvalidIndex string
+
+func (e errInvalidIndex) Error() string {
+	return "invalid path token '" + string(e) + "'"
+}
+
+// applyMergePatch applies an RFC 7386 merge patch.
+func applyMergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+			continue
+		}
+		d[k] = applyMergePatch(d[k], v)
+	}
+	return d
+}
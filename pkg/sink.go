@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	kclient "github.com/glerchundi/kubelistener/pkg/client"
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	"golang.org/x/net/context"
+)
+
+// Sink is an output for the events produced by the informer.
+type Sink interface {
+	// Open acquires the resources needed to start emitting events.
+	Open() error
+	// Emit delivers a single event, it may be buffered until Flush. Once
+	// ctx is done it must not wait to retry a failed delivery.
+	Emit(ctx context.Context, e *Event) error
+	// Flush forces buffered events to be delivered.
+	Flush() error
+	// Close flushes and releases every resource acquired by Open.
+	Close() error
+}
+
+// PermanentError is returned by sinks for failures retrying would not fix,
+// e.g. a request rejected by the server.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// SinkFactory creates a sink from the URI provided by the user, using the
+// listener configuration for anything not present in it. The 'events' query
+// parameter is already consumed by the registry.
+type SinkFactory func(u *url.URL, config *Config) (Sink, error)
+
+var (
+	sinkFactoriesMu sync.Mutex
+	sinkFactories   = make(map[string]SinkFactory)
+)
+
+// RegisterSink makes a sink available for the given URI scheme.
+func RegisterSink(scheme string, factory SinkFactory) {
+	sinkFactoriesMu.Lock()
+	defer sinkFactoriesMu.Unlock()
+
+	if factory == nil {
+		panic("kubelistener: RegisterSink factory is nil")
+	}
+	if _, dup := sinkFactories[scheme]; dup {
+		panic("kubelistener: RegisterSink called twice for scheme " + scheme)
+	}
+	sinkFactories[scheme] = factory
+}
+
+func registeredSinks() []string {
+	sinkFactoriesMu.Lock()
+	defer sinkFactoriesMu.Unlock()
+
+	schemes := make([]string, 0, len(sinkFactories))
+	for scheme := range sinkFactories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// eventFilter holds the event types accepted by a sink, all of them if empty.
+type eventFilter map[kapi.EventType]bool
+
+var knownEventTypes = eventFilter{
+	kapi.Added:      true,
+	kapi.Modified:   true,
+	kapi.Deleted:    true,
+	kclient.Entered: true,
+	kclient.Left:    true,
+}
+
+func parseEventFilter(s string) (eventFilter, error) {
+	f := make(eventFilter)
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToUpper(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		et := kapi.EventType(t)
+		if !knownEventTypes[et] {
+			return nil, fmt.Errorf("unknown event type '%s'", t)
+		}
+		f[et] = true
+	}
+	return f, nil
+}
+
+func (f eventFilter) accepts(t kapi.EventType) bool {
+	return len(f) == 0 || f[t]
+}
+
+// filteredSink is a sink restricted to some event types.
+type filteredSink struct {
+	Sink
+	uri    string
+	filter eventFilter
+}
+
+// NewSink creates a sink from an URI like 'file:///var/log/events' or
+// 'stdout://?events=added,deleted'.
+func NewSink(uri string, config *Config) (Sink, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink '%s': %v", uri, err)
+	}
+
+	sinkFactoriesMu.Lock()
+	factory, ok := sinkFactories[strings.ToLower(u.Scheme)]
+	sinkFactoriesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink '%s', valid schemes are: %s", uri, strings.Join(registeredSinks(), ", "))
+	}
+
+	q := u.Query()
+	filter, err := parseEventFilter(q.Get("events"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink '%s': %v", uri, err)
+	}
+	q.Del("events")
+	u.RawQuery = q.Encode()
+
+	s, err := factory(u, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink '%s': %v", uri, err)
+	}
+
+	return &filteredSink{s, uri, filter}, nil
+}
+
+// sinkSet fans out every event to each of its sinks.
+type sinkSet []*filteredSink
+
+func newSinkSet(config *Config) (sinkSet, error) {
+	uris := config.sinkURIs()
+	ss := make(sinkSet, 0, len(uris))
+	for _, uri := range uris {
+		s, err := NewSink(uri, config)
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, s.(*filteredSink))
+	}
+	return ss, nil
+}
+
+func (ss sinkSet) Open() error {
+	for i, s := range ss {
+		if err := s.Open(); err != nil {
+			// release the ones already opened
+			for _, o := range ss[:i] {
+				o.Close()
+			}
+			return fmt.Errorf("unable to open sink '%s': %v", s.uri, err)
+		}
+	}
+	return nil
+}
+
+// Emit delivers e to every sink accepting its type. A failing sink does not
+// prevent the others from receiving the event.
+func (ss sinkSet) Emit(ctx context.Context, e *Event) error {
+	_, err := ss.emit(ctx, e)
+	return err
+}
+
+// emit is like Emit but also returns the sinks that failed, so delivery can
+// be retried just for them. Those failing permanently are not returned.
+func (ss sinkSet) emit(ctx context.Context, e *Event) (sinkSet, error) {
+	var failed sinkSet
+	var errs []string
+	for _, s := range ss {
+		if !s.filter.accepts(e.Type) {
+			continue
+		}
+		if err := s.Emit(ctx, e); err != nil {
+			if _, ok := err.(*PermanentError); !ok {
+				failed = append(failed, s)
+			}
+			errs = append(errs, fmt.Sprintf("%s: %v", s.uri, err))
+		}
+	}
+	return failed, joinErrors("unable to emit event", errs)
+}
+
+func (ss sinkSet) Flush() error {
+	var errs []string
+	for _, s := range ss {
+		if err := s.Flush(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", s.uri, err))
+		}
+	}
+	return joinErrors("unable to flush sinks", errs)
+}
+
+func (ss sinkSet) Close() error {
+	var errs []string
+	for _, s := range ss {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", s.uri, err))
+		}
+	}
+	return joinErrors("unable to close sinks", errs)
+}
+
+func joinErrors(msg string, errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", msg, strings.Join(errs, "; "))
+}
@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/glerchundi/logrus"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	RegisterSink("exec", newExecSink)
+}
+
+const (
+	defaultExecTimeout     = 30 * time.Second
+	defaultExecConcurrency = 1
+)
+
+// execSink runs a command per event, e.g.
+// 'exec:///usr/local/bin/hook?arg=--verbose&timeout=10s&concurrency=4'.
+// The object is written to the command stdin and the event metadata is
+// exposed through KUBELISTENER_* environment variables. With a write-ahead
+// log commands run synchronously so that failures are retried.
+type execSink struct {
+	path    string
+	args    []string
+	timeout time.Duration
+	sync    bool
+	// limits the number of concurrent invocations
+	sem chan struct{}
+	// tracks in-flight invocations
+	wg sync.WaitGroup
+}
+
+func newExecSink(u *url.URL, config *Config) (Sink, error) {
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, fmt.Errorf("no command was provided")
+	}
+
+	q := u.Query()
+
+	timeout := defaultExecTimeout
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout '%s': %v", v, err)
+		}
+		timeout = d
+	}
+
+	concurrency := defaultExecConcurrency
+	if v := q.Get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid concurrency '%s', must be a positive integer", v)
+		}
+		concurrency = n
+	}
+
+	return &execSink{
+		path:    path,
+		args:    q["arg"],
+		timeout: timeout,
+		sync:    config.WALDir != "",
+		sem:     make(chan struct{}, concurrency),
+	}, nil
+}
+
+func (s *execSink) Open() error {
+	if _, err := exec.LookPath(s.path); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Emit starts the command in background, blocking only while the
+// concurrency limit is reached. If synchronous it waits for the command
+// and fails unless it exits with status 0. Commands are killed once ctx is
+// done.
+func (s *execSink) Emit(ctx context.Context, e *Event) error {
+	stdin, err := json.Marshal(e.Object)
+	if err != nil {
+		return &PermanentError{fmt.Errorf("unable to serialize %s event: %v", e.Type, err)}
+	}
+
+	if s.sync {
+		return s.run(ctx, e, stdin)
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			<-s.sem
+			s.wg.Done()
+		}()
+		if err := s.run(ctx, e, stdin); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	return nil
+}
+
+// run runs the command for e, failing unless it exits with status 0 before
+// the timeout and ctx is done.
+func (s *execSink) run(ctx context.Context, e *Event, stdin []byte) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.path, s.args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), execEnv(e)...)
+	// run in its own process group so that children are killed on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	desc := fmt.Sprintf("'%s' for %s %s %s", s.path, e.Type, e.Kind, objectKey(e.Namespace, e.Name))
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to run %s: %v", desc, err)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-runCtx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-exited:
+		}
+	}()
+	err := cmd.Wait()
+	close(exited)
+	elapsed := time.Since(start)
+
+	if stdout.Len() > 0 {
+		log.Debugf("%s stdout: %s", desc, strings.TrimSpace(stdout.String()))
+	}
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return fmt.Errorf("%s cancelled after %v: %s", desc, elapsed, strings.TrimSpace(stderr.String()))
+	case err != nil && runCtx.Err() != nil:
+		return fmt.Errorf("%s killed after %v: %s", desc, s.timeout, strings.TrimSpace(stderr.String()))
+	case err != nil:
+		return fmt.Errorf("%s exited with status %d after %v: %s", desc, exitStatus(err), elapsed, strings.TrimSpace(stderr.String()))
+	}
+
+	log.Infof("%s exited with status 0 after %v", desc, elapsed)
+	if stderr.Len() > 0 {
+		log.Warnf("%s stderr: %s", desc, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Flush is a no-op, in-flight invocations are not waited for so that a slow
+// command does not hold up the flush of the other outputs. Synchronous ones
+// are done by the time Emit returns.
+func (s *execSink) Flush() error {
+	return nil
+}
+
+// Close waits for every in-flight invocation to finish.
+func (s *execSink) Close() error {
+	s.wg.Wait()
+	return nil
+}
+
+func execEnv(e *Event) []string {
+	return []string{
+		"KUBELISTENER_EVENT_TYPE=" + string(e.Type),
+		"KUBELISTENER_KIND=" + e.Kind,
+		"KUBELISTENER_NAMESPACE=" + e.Namespace,
+		"KUBELISTENER_NAME=" + e.Name,
+		"KUBELISTENER_RESOURCE_VERSION=" + e.ResourceVersion,
+		"KUBELISTENER_CHANGED_FIELDS=" + strings.Join(e.ChangedFields, ","),
+	}
+}
+
+func exitStatus(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// objectKey returns the conventional 'namespace/name' key of an object.
+func objectKey(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	"golang.org/x/net/context"
+)
+
+func newTestExecSink(t *testing.T, command string, config *Config) *execSink {
+	u, err := url.Parse("exec:///bin/sh?arg=-c&arg=" + url.QueryEscape(command))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := newExecSink(u, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return sink.(*execSink)
+}
+
+// within fails unless fn returns before d.
+func within(t *testing.T, d time.Duration, what string, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("%s took longer than %v", what, d)
+	}
+}
+
+func TestExecSinkCancel(t *testing.T) {
+	for _, sync := range []bool{true, false} {
+		config := &Config{}
+		if sync {
+			config.WALDir = "/nonexistent"
+		}
+		sink := newTestExecSink(t, "sleep 30", config)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		within(t, 5*time.Second, "cancelling", func() {
+			err := sink.Emit(ctx, &Event{Type: kapi.Added, Kind: "Service", Name: "foo"})
+			if sync && err == nil {
+				t.Error("expected an error")
+			}
+			sink.Close()
+		})
+	}
+}
+
+func TestExecSinkFlush(t *testing.T) {
+	sink := newTestExecSink(t, "sleep 30", &Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := sink.Emit(ctx, &Event{Type: kapi.Added, Kind: "Service", Name: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	// a slow command does not hold up flushing
+	within(t, time.Second, "flushing", func() { sink.Flush() })
+
+	cancel()
+	within(t, 5*time.Second, "closing", func() { sink.Close() })
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+func init() {
+	RegisterSink("file", newFileSink)
+	RegisterSink("stdout", newStdSink(os.Stdout))
+	RegisterSink("stderr", newStdSink(os.Stderr))
+}
+
+// writerSink writes formatted events to a stream.
+type writerSink struct {
+	open   func() (io.WriteCloser, error)
+	format Formatter
+	wc     io.WriteCloser
+	bw     *bufio.Writer
+}
+
+// sinkFormatter returns the formatter requested through the 'format' query
+// parameter, or the configured one otherwise.
+func sinkFormatter(u *url.URL, config *Config) (Formatter, error) {
+	spec := u.Query().Get("format")
+	if spec == "" {
+		spec = config.OutputFormat
+	}
+	return newFormatter(spec)
+}
+
+func newFileSink(u *url.URL, config *Config) (Sink, error) {
+	// accept both file:///abs/path and file://rel/path
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, fmt.Errorf("no path was provided")
+	}
+
+	format, err := sinkFormatter(u, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writerSink{
+		open: func() (io.WriteCloser, error) {
+			return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		},
+		format: format,
+	}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func newStdSink(f *os.File) SinkFactory {
+	return func(u *url.URL, config *Config) (Sink, error) {
+		format, err := sinkFormatter(u, config)
+		if err != nil {
+			return nil, err
+		}
+
+		return &writerSink{
+			open: func() (io.WriteCloser, error) {
+				// standard streams outlive the sink
+				return nopCloser{f}, nil
+			},
+			format: format,
+		}, nil
+	}
+}
+
+func (s *writerSink) Open() error {
+	wc, err := s.open()
+	if err != nil {
+		return err
+	}
+	s.wc = wc
+	s.bw = bufio.NewWriter(wc)
+	return nil
+}
+
+func (s *writerSink) Emit(ctx context.Context, e *Event) error {
+	return s.format.Format(s.bw, e)
+}
+
+func (s *writerSink) Flush() error {
+	return s.bw.Flush()
+}
+
+func (s *writerSink) Close() error {
+	ferr := s.bw.Flush()
+	if err := s.wc.Close(); err != nil {
+		return err
+	}
+	return ferr
+}
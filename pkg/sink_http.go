@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	kclient "github.com/glerchundi/kubelistener/pkg/client"
+	log "github.com/glerchundi/logrus"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+func init() {
+	RegisterSink("http", newHTTPSink)
+	RegisterSink("https", newHTTPSink)
+}
+
+const (
+	// Header carrying the HMAC-SHA256 of the body, hex encoded.
+	signatureHeader = "X-Kubelistener-Signature"
+	// Upper bound for the delay between retries.
+	maxWebhookBackoff = 30 * time.Second
+)
+
+// httpSink POSTs every event envelope as JSON to an URL, retrying with an
+// exponential backoff on connection errors and 5xx responses.
+type httpSink struct {
+	url     string
+	client  *http.Client
+	header  http.Header
+	retries int
+	backoff time.Duration
+	// secret used to sign the body, if any
+	secretFile string
+	secret     []byte
+}
+
+func newHTTPSink(u *url.URL, config *Config) (Sink, error) {
+	header := make(http.Header)
+	for _, h := range config.WebhookHeaders {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header '%s', expected 'Name: value'", h)
+		}
+		header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	header.Set("Content-Type", "application/json")
+
+	return &httpSink{
+		url:        u.String(),
+		client:     &http.Client{Timeout: config.WebhookTimeout},
+		header:     header,
+		retries:    config.WebhookRetries,
+		backoff:    config.WebhookBackoff,
+		secretFile: config.WebhookSecretFile,
+	}, nil
+}
+
+func (s *httpSink) Open() error {
+	if s.secretFile == "" {
+		return nil
+	}
+
+	secret, err := ioutil.ReadFile(s.secretFile)
+	if err != nil {
+		return fmt.Errorf("unable to read webhook secret: %v", err)
+	}
+	s.secret = bytes.TrimSpace(secret)
+	if len(s.secret) == 0 {
+		return fmt.Errorf("webhook secret file '%s' is empty", s.secretFile)
+	}
+
+	return nil
+}
+
+// Emit posts e, waiting between retries unless ctx is done.
+func (s *httpSink) Emit(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return &PermanentError{fmt.Errorf("unable to serialize %s event: %v", e.Type, err)}
+	}
+
+	backoff := s.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, body, attempt)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return &PermanentError{err}
+		}
+		if attempt > s.retries || ctx.Err() != nil {
+			return err
+		}
+
+		log.Warnf("POST %s failed (attempt %d/%d), retrying in %v: %v", s.url, attempt, s.retries+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		if backoff *= 2; backoff > maxWebhookBackoff {
+			backoff = maxWebhookBackoff
+		}
+	}
+}
+
+// post delivers body once, giving up if ctx is done, and reports whether a
+// failure is worth retrying.
+func (s *httpSink) post(ctx context.Context, body []byte, attempt int) (bool, error) {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header = kclient.CopyHeader(s.header)
+	if s.secret != nil {
+		req.Header.Set(signatureHeader, "sha256="+sign(s.secret, body))
+	}
+
+	start := time.Now()
+	res, err := ctxhttp.Do(ctx, s.client, req)
+	if err != nil {
+		return true, err
+	}
+	// drain it to allow connection reuse
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	latency := time.Since(start)
+
+	log.Infof("POST %s %d in %v (attempt %d)", s.url, res.StatusCode, latency, attempt)
+
+	switch {
+	case res.StatusCode >= 500:
+		return true, fmt.Errorf("server error: %s", res.Status)
+	case res.StatusCode >= 300:
+		return false, fmt.Errorf("unexpected response: %s", res.Status)
+	}
+
+	return false, nil
+}
+
+// Flush is a no-op, events are delivered synchronously.
+func (s *httpSink) Flush() error {
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+// sign computes the hex encoded HMAC-SHA256 of body.
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+	"golang.org/x/net/context"
+)
+
+// webhookServer answers with the given statuses in turn, repeating the last
+// one, and counts the requests.
+type webhookServer struct {
+	*httptest.Server
+	requests int32
+	// last request
+	header http.Header
+	body   []byte
+}
+
+func newWebhookServer(statuses ...int) *webhookServer {
+	s := &webhookServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&s.requests, 1))
+		s.header = r.Header
+		s.body, _ = ioutil.ReadAll(r.Body)
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+	return s
+}
+
+func newTestHTTPSink(t *testing.T, s *webhookServer, config *Config) Sink {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.WebhookTimeout = time.Second
+	config.WebhookBackoff = time.Millisecond
+	sink, err := newHTTPSink(u, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+func TestHTTPSinkSignature(t *testing.T) {
+	s := newWebhookServer(http.StatusOK)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "kubelistener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newTestHTTPSink(t, s, &Config{WebhookSecretFile: secretFile, WebhookHeaders: []string{"X-Custom: value"}})
+	if err := sink.Emit(context.Background(), &Event{Type: kapi.Added, Kind: "Service", Name: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "sha256=" + sign([]byte("secret"), s.body); s.header.Get(signatureHeader) != expected {
+		t.Errorf("got signature '%s', expected '%s'", s.header.Get(signatureHeader), expected)
+	}
+	if s.header.Get("X-Custom") != "value" {
+		t.Errorf("got custom header '%s', expected 'value'", s.header.Get("X-Custom"))
+	}
+	if s.header.Get("Content-Type") != "application/json" {
+		t.Errorf("got content type '%s', expected 'application/json'", s.header.Get("Content-Type"))
+	}
+}
+
+func TestHTTPSinkRetries(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		statuses  []int
+		requests  int32
+		ok        bool
+		permanent bool
+	}{
+		{"server errors", []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK}, 3, true, false},
+		{"retries exhausted", []int{http.StatusBadGateway}, 3, false, false},
+		{"client error", []int{http.StatusBadRequest, http.StatusOK}, 1, false, true},
+	} {
+		s := newWebhookServer(test.statuses...)
+		sink := newTestHTTPSink(t, s, &Config{WebhookRetries: 2})
+		err := sink.Emit(context.Background(), &Event{Type: kapi.Added, Kind: "Service", Name: "foo"})
+		s.Close()
+
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if _, permanent := err.(*PermanentError); permanent != test.permanent {
+			t.Errorf("%s: got permanent error %v, expected %v", test.name, permanent, test.permanent)
+		}
+		if s.requests != test.requests {
+			t.Errorf("%s: got %d requests, expected %d", test.name, s.requests, test.requests)
+		}
+	}
+}
+
+func TestHTTPSinkCancel(t *testing.T) {
+	s := newWebhookServer(http.StatusServiceUnavailable)
+	defer s.Close()
+
+	sink := newTestHTTPSink(t, s, &Config{WebhookRetries: 5})
+	sink.(*httpSink).backoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sink.Emit(ctx, &Event{Type: kapi.Added, Kind: "Service", Name: "foo"})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retries not cancelled")
+	}
+}
+
+func TestHTTPSinkCancelRequest(t *testing.T) {
+	unblock := make(chan struct{})
+	s := &webhookServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer s.Close()
+	defer close(unblock)
+
+	sink := newTestHTTPSink(t, s, &Config{})
+	sink.(*httpSink).client.Timeout = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sink.Emit(ctx, &Event{Type: kapi.Added, Kind: "Service", Name: "foo"})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not cancelled")
+	}
+}